@@ -16,6 +16,9 @@ require (
 	github.com/gookit/event v1.1.2
 	github.com/ncruces/zenity v0.10.12
 	github.com/prometheus-community/pro-bing v0.4.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	go.starlark.net v0.0.0-20240725214946-42030a7cedce
+	golang.org/x/net v0.26.0
 	golang.org/x/sys v0.21.0
 	golang.org/x/term v0.21.0
 	gopkg.in/yaml.v2 v2.4.0
@@ -46,7 +49,6 @@ require (
 	github.com/rivo/uniseg v0.4.7 // indirect
 	github.com/xo/terminfo v0.0.0-20220910002029-abceb7e1c41e // indirect
 	golang.org/x/image v0.18.0 // indirect
-	golang.org/x/net v0.26.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
 )
@@ -17,16 +17,22 @@ package MITMApplication
 import (
 	"fmt"
 	"infinite-mitm/configs"
+	mitmService "infinite-mitm/internal/application/services/mitm"
+	"infinite-mitm/pkg/certificate"
 	"infinite-mitm/pkg/errors"
 	"infinite-mitm/pkg/integrity"
 	"infinite-mitm/pkg/mitm"
+	"infinite-mitm/pkg/proxy"
 	"infinite-mitm/pkg/resources"
 	"infinite-mitm/pkg/spinner"
 	"infinite-mitm/pkg/sysutilities"
+	"infinite-mitm/pkg/trust"
 	"infinite-mitm/pkg/updater"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/charmbracelet/huh"
 )
@@ -59,12 +65,69 @@ func Init() *errors.MITMError {
 		}
 	}
 
+	spinner.Run("Checking system proxy settings...")
+	if repaired, mitmErr := proxy.Repair(false); mitmErr != nil {
+		mitmErr.Log()
+	} else if repaired {
+		huh.NewConfirm().
+			Title("⚠️ The previous session did not exit cleanly; your system proxy settings have been restored.").
+			Affirmative("Sounds good!").
+			Negative("").
+			Run()
+	}
+
 	spinner.Run("Looking for root certificate...")
-	_, mitmErr = sysutilities.CheckForRootCertificate(configs.GetConfig().Proxy.Certificate.Name);
+	expired, mitmErr := certificate.Ensure(); if mitmErr != nil {
+		return mitmErr
+	}
+
+	// the expired root certificate is still trusted until it is removed
+	if expired != nil {
+		title := fmt.Sprintf("⚠️ Your root certificate expired on %s and has been replaced; the expired one has been removed from this computer.", expired.NotAfter.Format(time.DateOnly))
+		if mitmErr := sysutilities.UninstallRootCertificate(expired); mitmErr != nil {
+			title = fmt.Sprintf("⚠️ Your root certificate expired on %s and has been replaced; remove the expired one (SHA-256: %s) from your trust store.\n\n%s", expired.NotAfter.Format(time.DateOnly), certificate.Fingerprint(expired), mitmErr.String())
+		}
+
+		huh.NewConfirm().
+			Title(title).
+			Affirmative("Sounds good!").
+			Negative("").
+			Run()
+	}
+
+	// the root certificate shared by the previous versions lets anyone intercept the traffic of this computer
+	if legacy := certificate.Legacy(); legacy != nil {
+		if installed, _ := sysutilities.CheckForRootCertificate(legacy); installed {
+			title := "⚠️ The root certificate shared by the previous versions, whose private key is public, has been removed from this computer."
+			if mitmErr := sysutilities.UninstallRootCertificate(legacy); mitmErr != nil {
+				title = fmt.Sprintf("⚠️ The root certificate shared by the previous versions is still trusted, and its private key is public; remove %s (SHA-256: %s) from your trust store.\n\n%s", legacy.Subject.CommonName, certificate.LegacyFingerprint, mitmErr.String())
+			}
+
+			huh.NewConfirm().
+				Title(title).
+				Affirmative("Sounds good!").
+				Negative("").
+				Run()
+		}
+	}
+
+	rootCertificate, mitmErr := certificate.Read(); if mitmErr != nil {
+		return mitmErr
+	}
+
+	_, mitmErr = sysutilities.CheckForRootCertificate(rootCertificate);
 	if mitmErr != nil {
 		return mitmErr
 	}
 
+	if time.Until(rootCertificate.NotAfter) < certificate.ExpiryWarning {
+		huh.NewConfirm().
+			Title(fmt.Sprintf("⚠️ Your root certificate expires on %s; you may rotate it from the tools.", rootCertificate.NotAfter.Format(time.DateOnly))).
+			Affirmative("Sounds good!").
+			Negative("").
+			Run()
+	}
+
 	spinner.Run("Verifying local assets integrity...")
 	mitmErr = resources.CreateRootAssets(); if mitmErr != nil {
 		return mitmErr
@@ -104,3 +167,60 @@ func Init() *errors.MITMError {
 
 	return nil
 }
+
+// ReviewCommands asks the user to allow the mitm.yaml commands which were never reviewed (or changed, or whose executable was modified since);
+// the unselected ones are denied. The decisions are kept in the trust.yaml file, and the commands which are not allowed won't run.
+func ReviewCommands() *errors.MITMError {
+	content, mitmErr := mitm.ReadClientMITMConfig()
+	if mitmErr != nil || !content.Options.Commands.IsEnabled() {
+		return nil
+	}
+
+	unreviewed, unresolved := mitmService.GetUnreviewedCommands(content)
+	if len(unreviewed) == 0 {
+		return nil
+	}
+
+	description := "Select the commands to allow; the other ones are denied."
+	if len(unresolved) != 0 {
+		description += "\n\nThe following commands cannot be reviewed and won't run:\n" + strings.Join(unresolved, "\n")
+	}
+
+	options := make([]huh.Option[int], 0, len(unreviewed))
+	for i, command := range unreviewed {
+		options = append(options, huh.NewOption(command.String(), i))
+	}
+
+	var selected []int
+	err := huh.NewMultiSelect[int]().
+		Title(fmt.Sprintf("⚠️ Your %s runs the following commands; only allow the ones you trust.", mitm.MITMFilename)).
+		Description(description).
+		Options(options...).
+		Value(&selected).
+		Run()
+
+	if err != nil {
+		return errors.Create(errors.ErrPromptException, err.Error())
+	}
+
+	allowedSet := map[int]bool{}
+	for _, i := range selected {
+		allowedSet[i] = true
+	}
+
+	var allowed []trust.Command
+	var denied []trust.Command
+	for i, command := range unreviewed {
+		if allowedSet[i] {
+			allowed = append(allowed, command)
+		} else {
+			denied = append(denied, command)
+		}
+	}
+
+	if mitmErr := trust.Review(allowed, true); mitmErr != nil {
+		return mitmErr
+	}
+
+	return trust.Review(denied, false)
+}
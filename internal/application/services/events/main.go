@@ -14,23 +14,45 @@
 
 package MITMApplicationEventsService
 
+import "time"
+
 const (
 	RestartServer = "server.restart"
 	ProxyRequestSent = "request.sent"
 	ProxyResponseReceived = "response.received"
 	ProxyStatusMessage = "proxy.status_message"
+	ProxyStatsMessage = "proxy.stats_message"
+	ToggleChaos = "chaos.toggle"
+	ReportRuleHits = "rules.hits"
+	ResetRuleHits = "rules.reset"
+	ResetScenarios = "scenarios.reset"
 )
 
 const PayloadKey = "data"
 
+// CommandResult is the outcome of a rule command, displayed in the traffic details.
+type CommandResult struct {
+	Stage    string
+	Command  string
+	ExitCode int
+	Duration time.Duration
+	Output   string
+	Error    string
+}
+
 type ProxyRequestEventData struct {
 	ID          string
 	URL         string
 	Method      string
 	Headers     map[string]string
 	Body        []byte
+	BodySize    int64
 	Proxified   bool
 	SmartCached bool
+	Commands    []CommandResult
+
+	ClientAddress string
+	ClientName    string
 }
 
 type ProxyResponseEventData struct {
@@ -40,6 +62,11 @@ type ProxyResponseEventData struct {
 	Status      int
 	Headers     map[string]string
 	Body        []byte
+	BodySize    int64
 	Proxified   bool
 	SmartCached bool
+	Commands    []CommandResult
+
+	ClientAddress string
+	ClientName    string
 }
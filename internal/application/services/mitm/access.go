@@ -0,0 +1,135 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"infinite-mitm/configs"
+	helpers "infinite-mitm/internal/application/services/mitm/helpers"
+	"infinite-mitm/pkg/mitm"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// accessHandler guards the proxy when it is reachable from the network: loopback clients are always
+// allowed, other clients must match the allowlist and, when configured, send valid proxy credentials.
+type accessHandler struct {
+	next      http.Handler
+	allowlist []*net.IPNet
+	username  string
+	password  string
+}
+
+// private ranges accepted when no allowlist is configured
+var defaultAllowlist = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16", "fc00::/7", "fe80::/10"}
+
+const proxyAuthenticateRealm = "InfiniteMITM"
+
+func newAccessHandler(next http.Handler, options mitm.YAMLServerOptions) (*accessHandler, error) {
+	handler := &accessHandler{
+		next:     next,
+		username: options.Auth.Username,
+		password: options.Auth.Password,
+	}
+
+	entries := options.Allowlist
+	if len(entries) == 0 {
+		entries = defaultAllowlist
+	}
+
+	for _, entry := range entries {
+		network, err := helpers.ParseNetwork(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		handler.allowlist = append(handler.allowlist, network)
+	}
+
+	return handler, nil
+}
+
+func (h *accessHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ip := helpers.RemoteIP(req)
+	if ip != nil && ip.IsLoopback() {
+		h.next.ServeHTTP(w, req)
+		return
+	}
+
+	if ip == nil || !h.isAllowed(ip) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	// direct requests only reach the onboarding page, which does not expose anything sensitive
+	if h.username != "" && isProxyRequest(req) {
+		if !h.isAuthorized(req) {
+			w.Header().Set("Proxy-Authenticate", fmt.Sprintf("Basic realm=\"%s\"", proxyAuthenticateRealm))
+			http.Error(w, http.StatusText(http.StatusProxyAuthRequired), http.StatusProxyAuthRequired)
+			return
+		}
+
+		req.Header.Del("Proxy-Authorization")
+	}
+
+	h.next.ServeHTTP(w, req)
+}
+
+func (h *accessHandler) isAllowed(ip net.IP) bool {
+	for _, network := range h.allowlist {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *accessHandler) isAuthorized(req *http.Request) bool {
+	scheme, encoded, found := strings.Cut(req.Header.Get("Proxy-Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return false
+	}
+
+	username, password, found := strings.Cut(string(decoded), ":")
+	if !found {
+		return false
+	}
+
+	usernameMatch := subtle.ConstantTimeCompare([]byte(username), []byte(h.username)) == 1
+	passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(h.password)) == 1
+	return usernameMatch && passwordMatch
+}
+
+func getServerAddr(options mitm.YAMLServerOptions) string {
+	bind := options.Bind
+	if bind == "" {
+		bind = mitm.DefaultBind
+	}
+
+	return net.JoinHostPort(bind, fmt.Sprintf("%d", configs.GetConfig().Proxy.Port))
+}
+
+func isProxyRequest(req *http.Request) bool {
+	return req.Method == http.MethodConnect || req.URL.IsAbs()
+}
@@ -0,0 +1,157 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"fmt"
+	helpers "infinite-mitm/internal/application/services/mitm/helpers"
+	"infinite-mitm/pkg/domains"
+	"infinite-mitm/pkg/pattern"
+	"infinite-mitm/pkg/utilities"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/elazarl/goproxy"
+)
+
+// blocklist matches the requests of the "blocklist" section of the mitm.yaml, presets included.
+type blocklist struct {
+	rules []blocklistRule
+	hosts []string
+}
+
+type blocklistRule struct {
+	host    *helpers.InterceptHosts
+	// nil matches every path
+	path    *regexp.Regexp
+	methods []string
+	block   domains.YAMLDomainBlock
+}
+
+func newBlocklist(yaml domains.YAMLBlocklist) (*blocklist, error) {
+	rules, err := domains.GetBlocklistRules(yaml)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &blocklist{}
+	seen := map[string]bool{}
+
+	for _, rule := range rules {
+		block := domains.YAMLDomainBlock{Enabled: true, StatusCode: rule.StatusCode, Drop: rule.Drop}
+		if err := validateBlock(block); err != nil {
+			return nil, fmt.Errorf("invalid blocklist rule for %s; %s", rule.Host, err.Error())
+		}
+
+		var path *regexp.Regexp
+		if rule.Path != "" {
+			path = regexp.MustCompile("^" + pattern.Create("", rule.Path).String())
+		}
+
+		methods := make([]string, 0, len(rule.Methods))
+		for _, method := range rule.Methods {
+			methods = append(methods, strings.ToUpper(method))
+		}
+
+		list.rules = append(list.rules, blocklistRule{
+			host:    helpers.NewInterceptHosts([]string{rule.Host}),
+			path:    path,
+			methods: methods,
+			block:   block,
+		})
+
+		if !seen[rule.Host] {
+			seen[rule.Host] = true
+			list.hosts = append(list.hosts, rule.Host)
+		}
+	}
+
+	return list, nil
+}
+
+// Hosts returns the host patterns of the rules, using the "intercept_hosts" syntax.
+func (b *blocklist) Hosts() []string {
+	return b.hosts
+}
+
+func (b *blocklist) HasHost(hostname string) bool {
+	for _, rule := range b.rules {
+		if rule.host.Matches(hostname) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Match returns how to block the request, or nil when no rule matches it.
+func (b *blocklist) Match(req *http.Request) *domains.YAMLDomainBlock {
+	for i := range b.rules {
+		rule := &b.rules[i]
+		if !rule.host.Matches(req.URL.Hostname()) {
+			continue
+		}
+
+		if len(rule.methods) != 0 && !utilities.Contains(rule.methods, req.Method) {
+			continue
+		}
+
+		if rule.path != nil && !rule.path.MatchString(req.URL.Path) {
+			continue
+		}
+
+		return &rule.block
+	}
+
+	return nil
+}
+
+func validateBlock(block domains.YAMLDomainBlock) error {
+	if block.StatusCode != 0 && (block.StatusCode < 100 || block.StatusCode > 599) {
+		return fmt.Errorf("invalid block code: %d", block.StatusCode)
+	}
+
+	if block.StatusCode != 0 && block.Drop {
+		return fmt.Errorf("code and drop cannot be used together")
+	}
+
+	return nil
+}
+
+// blockRequest answers the request without contacting the service; nil is returned when the connection is dropped.
+func blockRequest(block domains.YAMLDomainBlock, req *http.Request, ctx *goproxy.ProxyCtx) *http.Response {
+	stats.blocked.Add(1)
+
+	if block.Drop {
+		dropConnection(ctx, fmt.Errorf("connection dropped by block rule"))
+		return nil
+	}
+
+	statusCode := block.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusNoContent
+	}
+
+	return goproxy.NewResponse(req, goproxy.ContentTypeText, statusCode, "")
+}
+
+// dropConnection makes the round trip of the request fail; goproxy then closes the connection of the client
+// without any response (decrypted requests) or answers with a 500 error (plain HTTP requests).
+func dropConnection(ctx *goproxy.ProxyCtx, err error) {
+	ctx.RoundTripper = goproxy.RoundTripperFunc(func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Response, error) {
+		return nil, err
+	})
+}
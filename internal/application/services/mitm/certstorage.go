@@ -0,0 +1,97 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+	"time"
+)
+
+// CertStorage keeps the leaf certificates signed for each intercepted hostname so
+// that they are generated once instead of on every CONNECT.
+type CertStorage struct {
+	ca    []byte
+	certs map[string]*certStorageItem
+	mutex sync.RWMutex
+}
+
+type certStorageItem struct {
+	cert    *tls.Certificate
+	expires time.Time
+}
+
+// leaf certificates are renewed a little before they expire to avoid handing out one that lapses mid-session
+const certRenewMargin = 24 * time.Hour
+
+var certStorage = NewCertStorage()
+
+func NewCertStorage() *CertStorage {
+	return &CertStorage{
+		certs: make(map[string]*certStorageItem),
+	}
+}
+
+func (s *CertStorage) Fetch(hostname string, gen func() (*tls.Certificate, error)) (*tls.Certificate, error) {
+	s.mutex.RLock()
+	item, exists := s.certs[hostname]
+	s.mutex.RUnlock()
+
+	if exists && time.Now().Add(certRenewMargin).Before(item.expires) {
+		return item.cert, nil
+	}
+
+	cert, err := gen()
+	if err != nil {
+		return nil, err
+	}
+
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+
+		cert.Leaf = leaf
+	}
+
+	s.mutex.Lock()
+	s.certs[hostname] = &certStorageItem{cert: cert, expires: cert.Leaf.NotAfter}
+	s.mutex.Unlock()
+
+	return cert, nil
+}
+
+// UseCA drops the cached leaf certificates when they were signed by another root CA.
+func (s *CertStorage) UseCA(ca tls.Certificate) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(ca.Certificate) == 0 || bytes.Equal(s.ca, ca.Certificate[0]) {
+		return
+	}
+
+	s.ca = ca.Certificate[0]
+	s.certs = make(map[string]*certStorageItem)
+}
+
+// Reset drops every cached leaf certificate.
+func (s *CertStorage) Reset() {
+	s.mutex.Lock()
+	s.certs = make(map[string]*certStorageItem)
+	s.mutex.Unlock()
+}
@@ -0,0 +1,254 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"fmt"
+	eventsService "infinite-mitm/internal/application/services/events"
+	"infinite-mitm/pkg/domains"
+	"infinite-mitm/pkg/throttle"
+	"infinite-mitm/pkg/utilities"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/elazarl/goproxy"
+	"github.com/gookit/event"
+)
+
+const (
+	chaosFaultStatus   = "status"
+	chaosFaultReset    = "reset"
+	chaosFaultTimeout  = "timeout"
+	chaosFaultTruncate = "truncate"
+)
+
+const (
+	defaultChaosStatusCode = http.StatusServiceUnavailable
+	defaultChaosTimeout    = 30 * time.Second
+	defaultChaosTruncate   = 1024
+)
+
+// chaosRule is the parsed "chaos" block of a rule.
+type chaosRule struct {
+	delay       time.Duration
+	delayMax    time.Duration
+	bandwidth   int64
+	fault       string
+	probability float64
+	statusCode  int
+	timeout     time.Duration
+	// bytes of the body sent before the transfer fails; -1 keeps half of the body (or 1KB when its length is unknown)
+	truncate    int64
+}
+
+// truncatedReader fails once the limit is reached, as would a connection dropped mid-transfer.
+type truncatedReader struct {
+	source io.Reader
+	left   int64
+}
+
+// chaos rules are applied until toggled off from the network view; the state survives the proxy restarts
+var chaosDisabled atomic.Bool
+
+// ToggleChaos enables or disables the chaos rules without restarting the proxy server.
+func ToggleChaos() {
+	disabled := chaosDisabled.Load()
+	chaosDisabled.Store(!disabled)
+
+	state := "off"
+	if disabled {
+		state = "on"
+	}
+
+	event.MustFire(eventsService.ProxyStatusMessage, event.M{
+		"details": fmt.Sprintf("chaos rules: %s", state),
+	})
+}
+
+func isChaosEnabled() bool {
+	return !chaosDisabled.Load()
+}
+
+// parseChaos validates the "chaos" block of a rule; nil is returned when the block is empty.
+func parseChaos(chaos domains.YAMLDomainChaos) (*chaosRule, error) {
+	if chaos.Delay == "" && chaos.DelayMax == "" && chaos.Bandwidth == "" && chaos.Fault.Type == "" {
+		return nil, nil
+	}
+
+	rule := &chaosRule{probability: 1, statusCode: defaultChaosStatusCode, timeout: defaultChaosTimeout, truncate: -1}
+
+	var err error
+	if chaos.Delay != "" {
+		if rule.delay, err = time.ParseDuration(chaos.Delay); err != nil || rule.delay < 0 {
+			return nil, fmt.Errorf("invalid delay: %s", chaos.Delay)
+		}
+	}
+
+	rule.delayMax = rule.delay
+	if chaos.DelayMax != "" {
+		if rule.delayMax, err = time.ParseDuration(chaos.DelayMax); err != nil || rule.delayMax < rule.delay {
+			return nil, fmt.Errorf("invalid delay_max: %s (expected a duration greater than the delay)", chaos.DelayMax)
+		}
+	}
+
+	if chaos.Bandwidth != "" {
+		if rule.bandwidth, err = utilities.ParseByteSize(chaos.Bandwidth); err != nil || rule.bandwidth <= 0 {
+			return nil, fmt.Errorf("invalid bandwidth: %s", chaos.Bandwidth)
+		}
+	}
+
+	fault := chaos.Fault
+	rule.fault = strings.ToLower(fault.Type)
+
+	switch rule.fault {
+	case "":
+		return rule, nil
+	case chaosFaultStatus, chaosFaultReset, chaosFaultTimeout, chaosFaultTruncate:
+	default:
+		return nil, fmt.Errorf("unsupported fault type: %s (expected status, reset, timeout or truncate)", fault.Type)
+	}
+
+	if fault.Probability != nil {
+		if *fault.Probability < 0 || *fault.Probability > 1 {
+			return nil, fmt.Errorf("invalid fault probability: %g (expected a value between 0 and 1)", *fault.Probability)
+		}
+
+		rule.probability = *fault.Probability
+	}
+
+	if fault.StatusCode != 0 {
+		if fault.StatusCode < 100 || fault.StatusCode > 599 {
+			return nil, fmt.Errorf("invalid fault code: %d", fault.StatusCode)
+		}
+
+		rule.statusCode = fault.StatusCode
+	}
+
+	if fault.Timeout != "" {
+		if rule.timeout, err = time.ParseDuration(fault.Timeout); err != nil || rule.timeout <= 0 {
+			return nil, fmt.Errorf("invalid fault timeout: %s", fault.Timeout)
+		}
+	}
+
+	if fault.Truncate != "" {
+		if rule.truncate, err = utilities.ParseByteSize(fault.Truncate); err != nil || rule.truncate < 0 {
+			return nil, fmt.Errorf("invalid fault truncate: %s", fault.Truncate)
+		}
+	}
+
+	return rule, nil
+}
+
+// onRequest reports whether the rule delays or fails the requests.
+func (c *chaosRule) onRequest() bool {
+	return c != nil && (c.delayMax > 0 || c.fault == chaosFaultStatus || c.fault == chaosFaultReset || c.fault == chaosFaultTimeout)
+}
+
+// onResponse reports whether the rule alters the response body stream.
+func (c *chaosRule) onResponse() bool {
+	return c != nil && (c.bandwidth > 0 || c.fault == chaosFaultTruncate)
+}
+
+// triggers draws whether the given fault occurs for the current request.
+func (c *chaosRule) triggers(fault string) bool {
+	return c.fault == fault && rand.Float64() < c.probability
+}
+
+func (c *chaosRule) wait() {
+	delay := c.delay
+	if c.delayMax > c.delay {
+		delay += time.Duration(rand.Int63n(int64(c.delayMax - c.delay) + 1))
+	}
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// applyRequestChaos delays the request, then either answers it with the fault status, or makes its round trip fail;
+// goproxy closes the connection of the client when the round trip of a decrypted request fails.
+func applyRequestChaos(rule *chaosRule, req *http.Request, ctx *goproxy.ProxyCtx) (*http.Response, bool) {
+	if !rule.onRequest() || !isChaosEnabled() {
+		return nil, false
+	}
+
+	rule.wait()
+
+	switch {
+	case rule.triggers(chaosFaultStatus):
+		return goproxy.NewResponse(req, goproxy.ContentTypeText, rule.statusCode, http.StatusText(rule.statusCode)), true
+	case rule.triggers(chaosFaultReset):
+		dropConnection(ctx, fmt.Errorf("connection reset by chaos rule"))
+		return nil, true
+	case rule.triggers(chaosFaultTimeout):
+		timeout := rule.timeout
+		ctx.RoundTripper = goproxy.RoundTripperFunc(func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Response, error) {
+			select {
+			case <-time.After(timeout):
+			case <-req.Context().Done():
+			}
+
+			return nil, fmt.Errorf("timeout by chaos rule")
+		})
+
+		return nil, true
+	}
+
+	return nil, false
+}
+
+// applyResponseChaos truncates and throttles the response body while it is streamed to the client.
+func applyResponseChaos(rule *chaosRule, resp *http.Response) {
+	if !rule.onResponse() || !isChaosEnabled() || resp.Body == nil || resp.Body == http.NoBody {
+		return
+	}
+
+	var reader io.Reader = resp.Body
+	if rule.triggers(chaosFaultTruncate) {
+		limit := rule.truncate
+		if limit < 0 {
+			limit = defaultChaosTruncate
+			if resp.ContentLength > 0 {
+				limit = resp.ContentLength / 2
+			}
+		}
+
+		reader = &truncatedReader{source: reader, left: limit}
+	}
+
+	reader = throttle.NewLimiter(rule.bandwidth).Reader(reader)
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{reader, resp.Body}
+}
+
+func (r *truncatedReader) Read(p []byte) (int, error) {
+	if r.left <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	if int64(len(p)) > r.left {
+		p = p[:r.left]
+	}
+
+	n, err := r.source.Read(p)
+	r.left -= int64(n)
+	return n, err
+}
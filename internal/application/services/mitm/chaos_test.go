@@ -0,0 +1,88 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"infinite-mitm/pkg/domains"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseChaos(t *testing.T) {
+	half := 0.5
+	invalidProbability := 1.5
+
+	tests := []struct {
+		name    string
+		chaos   domains.YAMLDomainChaos
+		want    *chaosRule
+		wantErr bool
+	}{
+		{"empty", domains.YAMLDomainChaos{}, nil, false},
+		{
+			"delay range",
+			domains.YAMLDomainChaos{Delay: "100ms", DelayMax: "1s"},
+			&chaosRule{delay: 100 * time.Millisecond, delayMax: time.Second, probability: 1, statusCode: defaultChaosStatusCode, timeout: defaultChaosTimeout, truncate: -1},
+			false,
+		},
+		{
+			"bandwidth",
+			domains.YAMLDomainChaos{Bandwidth: "2KB"},
+			&chaosRule{bandwidth: 2048, probability: 1, statusCode: defaultChaosStatusCode, timeout: defaultChaosTimeout, truncate: -1},
+			false,
+		},
+		{
+			"status fault",
+			domains.YAMLDomainChaos{Fault: domains.YAMLDomainChaosFault{Type: "Status", Probability: &half, StatusCode: http.StatusBadGateway}},
+			&chaosRule{fault: chaosFaultStatus, probability: 0.5, statusCode: http.StatusBadGateway, timeout: defaultChaosTimeout, truncate: -1},
+			false,
+		},
+		{
+			"truncate fault",
+			domains.YAMLDomainChaos{Fault: domains.YAMLDomainChaosFault{Type: "truncate", Truncate: "1KB"}},
+			&chaosRule{fault: chaosFaultTruncate, probability: 1, statusCode: defaultChaosStatusCode, timeout: defaultChaosTimeout, truncate: 1024},
+			false,
+		},
+		{
+			"timeout fault",
+			domains.YAMLDomainChaos{Fault: domains.YAMLDomainChaosFault{Type: "timeout", Timeout: "5s"}},
+			&chaosRule{fault: chaosFaultTimeout, probability: 1, statusCode: defaultChaosStatusCode, timeout: 5 * time.Second, truncate: -1},
+			false,
+		},
+		{"invalid delay", domains.YAMLDomainChaos{Delay: "soon"}, nil, true},
+		{"negative delay", domains.YAMLDomainChaos{Delay: "-1s"}, nil, true},
+		{"delay_max below delay", domains.YAMLDomainChaos{Delay: "2s", DelayMax: "1s"}, nil, true},
+		{"invalid bandwidth", domains.YAMLDomainChaos{Bandwidth: "0"}, nil, true},
+		{"unsupported fault", domains.YAMLDomainChaos{Fault: domains.YAMLDomainChaosFault{Type: "panic"}}, nil, true},
+		{"invalid probability", domains.YAMLDomainChaos{Fault: domains.YAMLDomainChaosFault{Type: "reset", Probability: &invalidProbability}}, nil, true},
+		{"invalid status code", domains.YAMLDomainChaos{Fault: domains.YAMLDomainChaosFault{Type: "status", StatusCode: 42}}, nil, true},
+		{"invalid timeout", domains.YAMLDomainChaos{Fault: domains.YAMLDomainChaosFault{Type: "timeout", Timeout: "0s"}}, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseChaos(test.chaos)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseChaos() error = %v, wantErr %t", err, test.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseChaos() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -15,7 +15,6 @@
 package MITMApplicationMITMService
 
 import (
-	"bytes"
 	"fmt"
 	eventsService "infinite-mitm/internal/application/services/events"
 	handlers "infinite-mitm/internal/application/services/mitm/handlers"
@@ -31,10 +30,8 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/elazarl/goproxy"
@@ -86,14 +83,17 @@ func WatchClientMITMConfig(stopChan <-chan struct{}) {
 	}
 }
 
-func CreateClientMITMHandlers(yaml mitm.YAML) ([]handlers.RequestHandlerStruct, []handlers.ResponseHandlerStruct, int, int) {
+func CreateClientMITMHandlers(yaml mitm.YAML, customDomains []domains.CustomDomain) ([]handlers.RequestHandlerStruct, []handlers.ResponseHandlerStruct, int, int) {
 	var clientRequestHandlers []handlers.RequestHandlerStruct
 	var clientResponseHandlers []handlers.ResponseHandlerStruct
 
 	var totalActiveReqHandlers int
 	var totalActiveRespHandlers int
 
-	for _, pair := range domains.GetYAMLContentDomainPairs(yaml.Domains) {
+	reportIgnoredCustomDomains(yaml.Domains, customDomains)
+	resetRuleStates()
+
+	for _, pair := range domains.GetYAMLContentDomainPairs(yaml.Domains, customDomains) {
 		reqHandlers, respHandlers, activeReqHandlers, activeRespHandlers := processNodes(pair.Content, pair.Domain)
 		clientRequestHandlers = append(clientRequestHandlers, reqHandlers...)
 		clientResponseHandlers = append(clientResponseHandlers, respHandlers...)
@@ -105,6 +105,32 @@ func CreateClientMITMHandlers(yaml mitm.YAML) ([]handlers.RequestHandlerStruct,
 	return clientRequestHandlers, clientResponseHandlers, totalActiveReqHandlers, totalActiveRespHandlers
 }
 
+// reportIgnoredCustomDomains warns about the "domains" keys which are not declared in the "custom_domains", or not intercepted.
+func reportIgnoredCustomDomains(yamlDomains domains.YAMLDomains, customDomains []domains.CustomDomain) {
+	for alias, nodes := range yamlDomains.Custom {
+		if len(nodes) == 0 {
+			continue
+		}
+
+		reason := "unknown domain; declare it in custom_domains"
+		for _, customDomain := range customDomains {
+			if customDomain.Alias == alias {
+				reason = "intercept is disabled"
+				if customDomain.Intercept {
+					reason = ""
+				}
+
+				break
+			}
+		}
+
+		if reason != "" {
+			mitmErr := errors.Create(errors.ErrYAMLReadException, fmt.Sprintf("rules for %s ignored; %s", alias, reason))
+			event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
+		}
+	}
+}
+
 func processNodes(contentList []domains.YAMLDomainNode, domain domains.DomainType) ([]handlers.RequestHandlerStruct, []handlers.ResponseHandlerStruct, int, int) {
 	var clientRequestHandlers []handlers.RequestHandlerStruct
 	var clientResponseHandlers []handlers.ResponseHandlerStruct
@@ -113,20 +139,79 @@ func processNodes(contentList []domains.YAMLDomainNode, domain domains.DomainTyp
 	var activeRespHandlers int
 
 	for _, v := range contentList {
-		hijackResponse := v.Response.Body != ""
-		overrideRequest := v.Request.Body != "" || len(v.Request.Headers) != 0 || len(v.Request.Before.Commands) != 0
-		overrideResponse := hijackResponse || len(v.Response.Headers) != 0 || len(v.Response.Before.Commands) != 0 || v.Response.StatusCode != 0
+		scope, err := helpers.NewClientScope(v.Clients)
+		if err != nil {
+			mitmErr := errors.Create(errors.ErrYAMLReadException, fmt.Sprintf("invalid clients for %s; %s", v.Path, err.Error()))
+			event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
+			continue
+		}
+
+		var hooks scriptHooks
+		if v.Script != "" {
+			if hooks, err = getScriptHooks(v.Script); err != nil {
+				mitmErr := errors.Create(errors.ErrScriptException, fmt.Sprintf("invalid script for %s; %s", v.Path, err.Error()))
+				event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
+			}
+		}
+
+		chaos, err := parseChaos(v.Chaos)
+		if err != nil {
+			mitmErr := errors.Create(errors.ErrYAMLReadException, fmt.Sprintf("invalid chaos for %s; %s", v.Path, err.Error()))
+			event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
+		}
+
+		if err := validateBlock(v.Block); err != nil {
+			mitmErr := errors.Create(errors.ErrYAMLReadException, fmt.Sprintf("invalid block for %s; %s", v.Path, err.Error()))
+			event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
+			v.Block.Enabled = false
+		}
+
+		if err := validateRewrite(v.Request.Rewrite); err != nil {
+			mitmErr := errors.Create(errors.ErrYAMLReadException, fmt.Sprintf("invalid rewrite for %s; %s", v.Path, err.Error()))
+			event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
+			v.Request.Rewrite = domains.YAMLDomainRewrite{}
+		}
+
+		scenario, err := newScenarioRule(v.Scenario)
+		if err != nil {
+			mitmErr := errors.Create(errors.ErrYAMLReadException, fmt.Sprintf("invalid scenario for %s; %s", v.Path, err.Error()))
+			event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
+			continue
+		}
+
+		for _, source := range bodyTemplateSources(v) {
+			if err := checkBodyTemplate(source); err != nil {
+				mitmErr := errors.Create(errors.ErrYAMLReadException, fmt.Sprintf("invalid body_template for %s; %s", v.Path, err.Error()))
+				event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
+			}
+		}
+
+		extractors, err := newExtractors(v.Extract)
+		if err != nil {
+			mitmErr := errors.Create(errors.ErrYAMLReadException, fmt.Sprintf("invalid extract for %s; %s", v.Path, err.Error()))
+			event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
+		}
+
+		state := newRuleState(domain, v, scenario)
+		hijackResponse := v.Response.Body != "" || hasVariantBody(v.Response.Variants)
+		overrideRequest := v.Block.Enabled || scenario.transitions() || hooks.request || chaos.onRequest() || hasRewrite(v.Request.Rewrite) || v.Request.Body != "" || v.Request.BodyTemplate != "" || len(v.Request.Headers) != 0 || len(v.Request.Before.Commands) != 0
+		overrideResponse := hijackResponse || hooks.response || len(extractors) != 0 || chaos.onResponse() || v.Response.BodyTemplate != "" || len(v.Response.Headers) != 0 || len(v.Response.Before.Commands) != 0 || len(v.Response.After.Commands) != 0 || len(v.Response.Variants) != 0 || v.Response.StatusCode != 0
 
 		if hijackResponse {
-			clientRequestHandlers = append(clientRequestHandlers, *createRequestHandler(domain, v, createResponseHandler(domain, v)))
+			clientRequestHandlers = append(clientRequestHandlers, *createRequestHandler(domain, v, scope, chaos, state, createResponseHandler(domain, v, scope, chaos, state, extractors)))
 			activeRespHandlers++
+
+			// the variants without body alter the response of the service instead
+			if len(v.Response.Variants) != 0 {
+				clientResponseHandlers = append(clientResponseHandlers, *createResponseHandler(domain, v, scope, chaos, state, extractors))
+			}
 		} else if overrideResponse {
-			clientResponseHandlers = append(clientResponseHandlers, *createResponseHandler(domain, v))
+			clientResponseHandlers = append(clientResponseHandlers, *createResponseHandler(domain, v, scope, chaos, state, extractors))
 			activeRespHandlers++
 		}
 
 		if overrideRequest {
-			clientRequestHandlers = append(clientRequestHandlers, *createRequestHandler(domain, v, nil))
+			clientRequestHandlers = append(clientRequestHandlers, *createRequestHandler(domain, v, scope, chaos, state, nil))
 			activeReqHandlers++
 		}
 	}
@@ -134,10 +219,14 @@ func processNodes(contentList []domains.YAMLDomainNode, domain domains.DomainTyp
 	return clientRequestHandlers, clientResponseHandlers, activeReqHandlers, activeRespHandlers
 }
 
-func createRequestHandler(domain domains.DomainType, node domains.YAMLDomainNode, responseHandler *handlers.ResponseHandlerStruct) *handlers.RequestHandlerStruct {
+func createRequestHandler(domain domains.DomainType, node domains.YAMLDomainNode, scope *helpers.ClientScope, chaos *chaosRule, state *ruleState, responseHandler *handlers.ResponseHandlerStruct) *handlers.RequestHandlerStruct {
 	target := pattern.Create(domain, node.Path)
+	match := helpers.MatchRequestURL(target, scope)
 	return &handlers.RequestHandlerStruct{
-		Match: helpers.MatchRequestURL(target),
+		Target: helpers.RuleTarget{Domain: domain, Path: node.Path},
+		Match: func(req *http.Request, ctx *goproxy.ProxyCtx) bool {
+			return state.active(ctx) && match(req, ctx)
+		},
 		Fn: func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
 			if !utilities.Contains(node.Methods, req.Method) {
 				return req, nil
@@ -148,41 +237,113 @@ func createRequestHandler(domain domains.DomainType, node domains.YAMLDomainNode
 				return req, nil
 			}
 
+			hit := state.hit(customCtx); if hit == nil {
+				return req, nil
+			}
+
 			pr := proxified.(map[string]bool); if !pr["req"] {
 				pr["req"] = true
 				customCtx.SetUserData(context.ProxyKey, pr)
 			}
 
+			if node.Block.Enabled {
+				customCtx.UnsetUserData(context.CacheKey)
+				return req, blockRequest(node.Block, req, ctx)
+			}
+
+			// the fault answers the request, or makes it fail; nothing else to override
+			if chaosResp, faulted := applyRequestChaos(chaos, req, ctx); faulted {
+				customCtx.UnsetUserData(context.CacheKey)
+				return req, chaosResp
+			}
+
 			body := node.Request.Body
 			matches := pattern.Match(target, request.StripPort(req.URL.String()))
-			beforeHandlers := node.Request.Before
 
 			for key, value := range node.Request.Headers {
-				req.Header.Set(key, pattern.ReplaceParameters(pattern.ReplaceMatches(value, matches)))
+				req.Header.Set(key, replaceVariables(value, matches))
+			}
+
+			beforeCommands := createCommands(requestBeforeStage, node.Request.Before, matches, req, 0)
+			results, err := runRequestCommands(beforeCommands, req)
+			if err != nil {
+				mitmErr := errors.Create(errors.ErrIOReadException, fmt.Sprintf("invalid request body for %s commands; %s", node.Path, err.Error()))
+				event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
 			}
 
-			beforeCommands := createCommands(beforeHandlers.Commands, matches)
-			runCommands(beforeCommands)
+			recordCommandResults(customCtx, results)
 
 			if body != "" {
-				buffer, _, err := readBodyFile(body, matches, req.Header)
+				reader, size, _, err := readBodyFile(body, matches, req.Header)
 				if err != nil {
 					mitmErr := errors.Create(errors.ErrIOReadException, fmt.Sprintf("invalid request body for %s; %s", body, err.Error()))
 					event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
-				} else if buffer != nil {
+				} else {
 					if req.Body != nil {
 						io.Copy(io.Discard, req.Body)
 						req.Body.Close()
 					}
 
-					bufferLength := len(buffer)
-					req.Body = io.NopCloser(bytes.NewBuffer(buffer))
-					req.ContentLength = int64(bufferLength)
-					req.Header.Set("Content-Length", fmt.Sprintf("%d", bufferLength))
+					req.Body = reader
+					req.ContentLength = size
+					setContentLength(req.Header, size)
 				}
 			}
 
-			if responseHandler != nil {
+			if node.Request.BodyTemplate != "" {
+				// the request is forwarded untouched when the template fails
+				data, original, err := readTrafficBody(req.Body, req.Header, 0)
+				if err != nil {
+					req.Body = original
+				} else {
+					var rendered []byte
+					if rendered, err = renderBodyTemplate(node.Request.BodyTemplate, matches, req, data); err == nil {
+						data = rendered
+					}
+
+					setRequestBody(req, data)
+				}
+
+				if err != nil {
+					mitmErr := errors.Create(errors.ErrIOReadException, fmt.Sprintf("invalid request body template for %s; %s", node.Path, err.Error()))
+					event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
+				}
+			}
+
+			if node.Script != "" {
+				scriptedResp, err := runRequestScript(node.Script, matches, req)
+				if err != nil {
+					mitmErr := errors.Create(errors.ErrScriptException, fmt.Sprintf("on_request failed for %s; %s", node.Path, err.Error()))
+					event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
+				}
+
+				// the script answered the request by itself
+				if scriptedResp != nil {
+					customCtx.UnsetUserData(context.CacheKey)
+					return req, scriptedResp
+				}
+			}
+
+			if hasRewrite(node.Request.Rewrite) {
+				location, err := rewriteURL(node.Request.Rewrite, matches, req.URL)
+				if err != nil {
+					mitmErr := errors.Create(errors.ErrYAMLReadException, fmt.Sprintf("invalid rewrite for %s; %s", node.Path, err.Error()))
+					event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
+				} else if node.Request.Rewrite.Redirect != 0 && location.String() == req.URL.String() {
+					// e.g., a path built from matches which resolves to the requested one; the client would loop
+					mitmErr := errors.Create(errors.ErrYAMLReadException, fmt.Sprintf("invalid rewrite for %s; redirect to the requested URL: %s", node.Path, location))
+					event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
+				} else if node.Request.Rewrite.Redirect != 0 {
+					customCtx.UnsetUserData(context.CacheKey)
+					return req, redirectRequest(req, location, node.Request.Rewrite.Redirect)
+				} else {
+					// sent by the proxy once the request handlers are done (see CreateServer)
+					customCtx.SetUserData(context.RewriteKey, location)
+				}
+			}
+
+			// the hit may be answered by a variant without body, or by the service once the sequence is over
+			if response, ok := hit.response(state, node.Response); responseHandler != nil && ok && response.Body != "" {
 				customCtx.UnsetUserData(context.CacheKey)
 				hijackedResp := responseHandler.Fn(&http.Response{
 					Request: req,
@@ -199,10 +360,14 @@ func createRequestHandler(domain domains.DomainType, node domains.YAMLDomainNode
 	}
 }
 
-func createResponseHandler(domain domains.DomainType, node domains.YAMLDomainNode) *handlers.ResponseHandlerStruct {
+func createResponseHandler(domain domains.DomainType, node domains.YAMLDomainNode, scope *helpers.ClientScope, chaos *chaosRule, state *ruleState, extractors []extractor) *handlers.ResponseHandlerStruct {
 	target := pattern.Create(domain, node.Path)
+	match := helpers.MatchResponseURL(target, scope)
 	return &handlers.ResponseHandlerStruct{
-		Match: helpers.MatchResponseURL(target),
+		Target: helpers.RuleTarget{Domain: domain, Path: node.Path},
+		Match: func(resp *http.Response, ctx *goproxy.ProxyCtx) bool {
+			return state.active(ctx) && match(resp, ctx)
+		},
 		Fn: func(resp *http.Response, ctx *goproxy.ProxyCtx) *http.Response {
 			if !utilities.Contains(node.Methods, resp.Request.Method) {
 				return resp
@@ -213,28 +378,44 @@ func createResponseHandler(domain domains.DomainType, node domains.YAMLDomainNod
 				return resp
 			}
 
+			// a hijacked response is not altered a second time by the response handler of the rule
+			hit := state.hit(customCtx); if hit == nil || hit.responded {
+				return resp
+			}
+
+			response, ok := hit.response(state, node.Response); if !ok {
+				return resp
+			}
+
+			hit.responded = true
+
 			pr := proxified.(map[string]bool); if !pr["resp"] {
 				pr["resp"] = true
 				customCtx.SetUserData(context.ProxyKey, pr)
 			}
 
-			body := node.Response.Body
+			body := response.Body
 			matches := pattern.Match(target, request.StripPort(resp.Request.URL.String()))
-			beforeHandlers := node.Response.Before
 
-			for key, value := range node.Response.Headers {
-				resp.Header.Set(key, pattern.ReplaceParameters(pattern.ReplaceMatches(value, matches)))
+			for key, value := range response.Headers {
+				resp.Header.Set(key, replaceVariables(value, matches))
+			}
+
+			beforeCommands := createCommands(responseBeforeStage, response.Before, matches, resp.Request, resp.StatusCode)
+			results, err := runResponseCommands(beforeCommands, resp)
+			if err != nil {
+				mitmErr := errors.Create(errors.ErrIOReadException, fmt.Sprintf("invalid response body for %s commands; %s", node.Path, err.Error()))
+				event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
 			}
 
-			beforeCommands := createCommands(beforeHandlers.Commands, matches)
-			runCommands(beforeCommands)
+			recordCommandResults(customCtx, results)
 
 			if body != "" {
-				buffer, httpHeader, err := readBodyFile(body, matches, resp.Request.Header)
+				reader, size, httpHeader, err := readBodyFile(body, matches, resp.Request.Header)
 				if err != nil {
 					mitmErr := errors.Create(errors.ErrIOReadException, fmt.Sprintf("invalid response body for %s; %s", body, err.Error()))
 					event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
-				} else if buffer != nil {
+				} else {
 					if resp.Body != nil {
 						io.Copy(io.Discard, resp.Body)
 						resp.Body.Close()
@@ -244,101 +425,140 @@ func createResponseHandler(domain domains.DomainType, node domains.YAMLDomainNod
 						resp.Header.Set(k, strings.Join(v, ", "))
 					}
 
-					bufferLength := len(buffer)
-					resp.Body = io.NopCloser(bytes.NewBuffer(buffer))
-					resp.ContentLength = int64(bufferLength)
-					resp.Header.Set("Content-Length", fmt.Sprintf("%d", bufferLength))
+					resp.Body = reader
+					resp.ContentLength = size
+					setContentLength(resp.Header, size)
 
 					resp.Status = http.StatusText(http.StatusOK)
 					resp.StatusCode = http.StatusOK
 				}
 			}
 
-			if node.Response.StatusCode != 0 {
-				resp.Status = http.StatusText(node.Response.StatusCode)
-				resp.StatusCode = node.Response.StatusCode
+			if response.BodyTemplate != "" {
+				// the response is forwarded untouched when the template fails
+				data, original, err := readTrafficBody(resp.Body, resp.Header, 0)
+				if err != nil {
+					resp.Body = original
+				} else {
+					var rendered []byte
+					if rendered, err = renderBodyTemplate(response.BodyTemplate, matches, resp.Request, data); err == nil {
+						data = rendered
+					}
+
+					setResponseBody(resp, data)
+				}
+
+				if err != nil {
+					mitmErr := errors.Create(errors.ErrIOReadException, fmt.Sprintf("invalid response body template for %s; %s", node.Path, err.Error()))
+					event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
+				}
+			}
+
+			if response.StatusCode != 0 {
+				resp.Status = http.StatusText(response.StatusCode)
+				resp.StatusCode = response.StatusCode
+			}
+
+			if node.Script != "" {
+				if err := runResponseScript(node.Script, matches, resp); err != nil {
+					mitmErr := errors.Create(errors.ErrScriptException, fmt.Sprintf("on_response failed for %s; %s", node.Path, err.Error()))
+					event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
+				}
+			}
+
+			afterCommands := createCommands(responseAfterStage, response.After, matches, resp.Request, resp.StatusCode)
+			results, err = runResponseCommands(afterCommands, resp)
+			if err != nil {
+				mitmErr := errors.Create(errors.ErrIOReadException, fmt.Sprintf("invalid response body for %s commands; %s", node.Path, err.Error()))
+				event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
+			}
+
+			recordCommandResults(customCtx, results)
+			if err := extractVariables(extractors, matches, resp); err != nil {
+				mitmErr := errors.Create(errors.ErrIOReadException, fmt.Sprintf("invalid response body for %s extract; %s", node.Path, err.Error()))
+				event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
 			}
 
+			applyResponseChaos(chaos, resp)
+
 			return resp
 		},
 	}
 }
 
-func isURL(str string) bool {
-	_, err := url.ParseRequestURI(str); if err != nil {
-		return false
+// recordCommandResults keeps the results of the commands for the traffic details.
+func recordCommandResults(customCtx *context.CustomProxyCtx, results []eventsService.CommandResult) {
+	if len(results) == 0 {
+		return
 	}
 
-	u, err := url.Parse(str)
-	return err == nil && u.Scheme != "" && u.Host != ""
+	previous, _ := customCtx.GetUserData(context.CommandsKey).([]eventsService.CommandResult)
+	customCtx.SetUserData(context.CommandsKey, append(previous, results...))
 }
 
-func createCommands(commands []domains.YAMLDomainTrafficRunCommand, matches []string) [][]string {
-	var commandsList [][]string
-
-	for _, command := range commands {
-		if len(command.Run) == 0 {
-			continue
-		}
-
-		runList := make([]string, 0, len(command.Run))
-		for _, run := range command.Run {
-			replace := pattern.ReplaceParameters(pattern.ReplaceMatches(run, matches))
-			if !isURL(replace) {
-				replace = filepath.Clean(replace)
-			}
-
-			runList = append(runList, replace)
+func hasVariantBody(variants []domains.YAMLDomainResponseVariant) bool {
+	for _, variant := range variants {
+		if variant.Body != "" {
+			return true
 		}
-
-		commandsList = append(commandsList, runList)
 	}
 
-	return commandsList
+	return false
 }
 
-func runCommands(commands [][]string) {
-	if len(commands) == 0 {
-		return
+// bodyTemplateSources returns the "body_template" of the request, the response and its variants.
+func bodyTemplateSources(node domains.YAMLDomainNode) []string {
+	sources := []string{node.Request.BodyTemplate, node.Response.BodyTemplate}
+	for _, variant := range node.Response.Variants {
+		sources = append(sources, variant.BodyTemplate)
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(len(commands))
-
-	for i, args := range commands {
-		go func(i int, args []string) {
-			defer wg.Done()
-
-			if len(args) == 0 {
-				return
-			}
+	return sources
+}
 
-			c := exec.Command(args[0], args[1:]...)
-			if err := c.Run(); err != nil {
-				event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": fmt.Sprintf("command #%d encountered an error: %s", i + 1, err.Error())})
-			}
-		}(i, args)
+func isURL(str string) bool {
+	_, err := url.ParseRequestURI(str); if err != nil {
+		return false
 	}
 
-	wg.Wait()
+	u, err := url.Parse(str)
+	return err == nil && u.Scheme != "" && u.Host != ""
 }
 
-func readBodyFile(body string, matches []string, header http.Header) ([]byte, http.Header, error) {
-	str := pattern.ReplaceParameters(pattern.ReplaceMatches(body, matches))
+// readBodyFile opens the overridden body without buffering it; size is -1 when unknown.
+func readBodyFile(body string, matches []string, header http.Header) (io.ReadCloser, int64, http.Header, error) {
+	str := replaceVariables(body, matches)
 
 	if isURL(str) {
-		buffer, httpHeader, mitmErr := request.Send("GET", str, nil, header);
+		resp, mitmErr := request.Open("GET", str, nil, header)
 		if mitmErr != nil {
-			return nil, httpHeader, fmt.Errorf(mitmErr.Message)
+			return nil, 0, http.Header{}, fmt.Errorf(mitmErr.Message)
 		}
 
-		return buffer, httpHeader, nil
+		httpHeader := resp.Header.Clone()
+		httpHeader.Del("Content-Length")
+		return resp.Body, resp.ContentLength, httpHeader, nil
 	}
 
-	buffer, err := os.ReadFile(filepath.Clean(str));
+	file, err := os.Open(filepath.Clean(str))
 	if err != nil {
-		return nil, http.Header{}, err
+		return nil, 0, http.Header{}, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, http.Header{}, err
+	}
+
+	return file, info.Size(), http.Header{}, nil
+}
+
+func setContentLength(header http.Header, size int64) {
+	if size < 0 {
+		header.Del("Content-Length")
+		return
 	}
 
-	return buffer, http.Header{}, nil
+	header.Set("Content-Length", fmt.Sprintf("%d", size))
 }
@@ -0,0 +1,449 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	eventsService "infinite-mitm/internal/application/services/events"
+	"infinite-mitm/pkg/domains"
+	"infinite-mitm/pkg/mitm"
+	"infinite-mitm/pkg/pattern"
+	"infinite-mitm/pkg/request"
+	"infinite-mitm/pkg/trust"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gookit/event"
+)
+
+const (
+	defaultCommandTimeout = 60 * time.Second
+	commandOutputLimit    = 4 * 1024
+)
+
+const (
+	requestBeforeStage  = "request.before"
+	responseBeforeStage = "response.before"
+	responseAfterStage  = "response.after"
+)
+
+type trafficCommand struct {
+	// the command as written in the mitm.yaml, checked against the trust.yaml before running
+	template trust.Command
+	args     []string
+	env      []string
+	stdin    bool
+	stdout   bool
+	timeout  time.Duration
+}
+
+// trafficCommands are the commands of a rule stage, with their parameters and matches replaced.
+type trafficCommands struct {
+	stage      string
+	list       []trafficCommand
+	sequential bool
+}
+
+var (
+	commandsEnabled      = true
+	commandsEnabledMutex sync.RWMutex
+)
+
+// matches (e.g., $1) and session variables (e.g., ${name}) which prevent an executable from being reviewed before the traffic
+var matchReferenceRegexp = regexp.MustCompile(`\$(\d|\{)`)
+
+// limitedBuffer keeps the beginning of the command output for the traffic details.
+type limitedBuffer struct {
+	buffer    bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	remaining := b.limit - b.buffer.Len()
+	if len(p) > remaining {
+		p = p[:max(remaining, 0)]
+		b.truncated = true
+	}
+
+	b.buffer.Write(p)
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buffer.String() + "\n[output truncated]"
+	}
+
+	return b.buffer.String()
+}
+
+// createCommands prepares the commands of a stage; they receive the request details and matches as MITM_* environment variables.
+func createCommands(stage string, commands domains.YAMLDomainTrafficCommands, matches []string, req *http.Request, status int) trafficCommands {
+	list := trafficCommands{stage: stage, sequential: commands.Sequential}
+	if len(commands.Commands) == 0 {
+		return list
+	}
+
+	env := []string{
+		"MITM_STAGE=" + stage,
+		"MITM_METHOD=" + req.Method,
+		"MITM_URL=" + request.StripPort(req.URL.String()),
+		"MITM_HOST=" + req.URL.Hostname(),
+		"MITM_PATH=" + req.URL.Path,
+	}
+
+	if status != 0 {
+		env = append(env, fmt.Sprintf("MITM_STATUS=%d", status))
+	}
+
+	for i, match := range matches {
+		env = append(env, fmt.Sprintf("MITM_MATCH_%d=%s", i + 1, match))
+	}
+
+	for _, command := range commands.Commands {
+		if len(command.Run) == 0 {
+			continue
+		}
+
+		runList := make([]string, 0, len(command.Run))
+		for _, run := range command.Run {
+			replace := replaceVariables(run, matches)
+			if !isURL(replace) {
+				replace = filepath.Clean(replace)
+			}
+
+			runList = append(runList, replace)
+		}
+
+		commandEnv := append([]string{}, env...)
+		for key, value := range command.Env {
+			commandEnv = append(commandEnv, key + "=" + replaceVariables(value, matches))
+		}
+
+		list.list = append(list.list, trafficCommand{
+			template: newCommandTemplate(command),
+			args: runList,
+			env: commandEnv,
+			stdin: command.Stdin == domains.CommandBody,
+			stdout: command.Stdout == domains.CommandBody,
+			timeout: parseTimeout(command.Timeout, defaultCommandTimeout),
+		})
+	}
+
+	return list
+}
+
+// usesBody reports whether a command reads or replaces the traffic body.
+func (c trafficCommands) usesBody() bool {
+	for _, command := range c.list {
+		if command.stdin || command.stdout {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runRequestCommands runs the commands, piping the request body through the ones using "stdin: body" or "stdout: body".
+func runRequestCommands(commands trafficCommands, req *http.Request) ([]eventsService.CommandResult, error) {
+	if !commands.usesBody() {
+		_, results := runCommands(commands, nil)
+		return results, nil
+	}
+
+	body, original, err := readTrafficBody(req.Body, req.Header, 0)
+	if err != nil {
+		// the request is forwarded untouched
+		req.Body = original
+		return nil, err
+	}
+
+	body, results := runCommands(commands, body)
+	setRequestBody(req, body)
+	return results, nil
+}
+
+// runResponseCommands runs the commands, piping the response body through the ones using "stdin: body" or "stdout: body".
+func runResponseCommands(commands trafficCommands, resp *http.Response) ([]eventsService.CommandResult, error) {
+	if !commands.usesBody() {
+		_, results := runCommands(commands, nil)
+		return results, nil
+	}
+
+	body, original, err := readTrafficBody(resp.Body, resp.Header, 0)
+	if err != nil {
+		// the response is forwarded untouched
+		resp.Body = original
+		return nil, err
+	}
+
+	body, results := runCommands(commands, body)
+	setResponseBody(resp, body)
+	return results, nil
+}
+
+// runCommands runs the commands in parallel, or one after the other when sequential; in parallel, every command reads the same
+// body and the last successful "stdout: body" command wins, while sequential commands read the output of the previous ones.
+func runCommands(commands trafficCommands, body []byte) ([]byte, []eventsService.CommandResult) {
+	if len(commands.list) == 0 {
+		return body, nil
+	}
+
+	results := make([]eventsService.CommandResult, len(commands.list))
+	if commands.sequential {
+		for i, command := range commands.list {
+			output, result := runCommand(i, commands.stage, command, body)
+			if output != nil {
+				body = output
+			}
+
+			results[i] = result
+		}
+
+		return body, results
+	}
+
+	outputs := make([][]byte, len(commands.list))
+
+	var wg sync.WaitGroup
+	wg.Add(len(commands.list))
+
+	for i, command := range commands.list {
+		go func(i int, command trafficCommand) {
+			defer wg.Done()
+			outputs[i], results[i] = runCommand(i, commands.stage, command, body)
+		}(i, command)
+	}
+
+	wg.Wait()
+
+	for _, output := range outputs {
+		if output != nil {
+			body = output
+		}
+	}
+
+	return body, results
+}
+
+// runCommand returns the standard output of a successful "stdout: body" command, or nil.
+func runCommand(index int, stage string, command trafficCommand, body []byte) ([]byte, eventsService.CommandResult) {
+	result := eventsService.CommandResult{Stage: stage, Command: strings.Join(command.args, " "), ExitCode: -1}
+	path, err := checkCommandTrust(command.template)
+	if err != nil {
+		result.Error = err.Error()
+		event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": fmt.Sprintf("%s command #%d blocked: %s", stage, index + 1, err.Error())})
+		return nil, result
+	}
+
+	ctx := context.Background()
+	if command.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, command.timeout)
+		defer cancel()
+	}
+
+	// runs the executable which was checked, rather than looking its name up again
+	c := exec.CommandContext(ctx, path, command.args[1:]...)
+	c.Env = append(os.Environ(), command.env...)
+	c.WaitDelay = time.Second
+
+	if command.stdin {
+		c.Stdin = bytes.NewReader(body)
+	}
+
+	var stdout bytes.Buffer
+	output := &limitedBuffer{limit: commandOutputLimit}
+	c.Stderr = output
+	c.Stdout = output
+	if command.stdout {
+		c.Stdout = &stdout
+	}
+
+	start := time.Now()
+	err = c.Run()
+	result.Duration = time.Since(start)
+	result.Output = output.String()
+
+	if c.ProcessState != nil {
+		result.ExitCode = c.ProcessState.ExitCode()
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", command.timeout)
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+		event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": fmt.Sprintf("%s command #%d encountered an error: %s", stage, index + 1, err.Error())})
+		return nil, result
+	}
+
+	if command.stdout {
+		return stdout.Bytes(), result
+	}
+
+	return nil, result
+}
+
+// setCommandsOptions applies the "commands" options of the mitm.yaml.
+func setCommandsOptions(options mitm.YAMLCommandsOptions) {
+	commandsEnabledMutex.Lock()
+	defer commandsEnabledMutex.Unlock()
+
+	commandsEnabled = options.IsEnabled()
+}
+
+func areCommandsEnabled() bool {
+	commandsEnabledMutex.RLock()
+	defer commandsEnabledMutex.RUnlock()
+
+	return commandsEnabled
+}
+
+// newCommandTemplate returns the command as written in the mitm.yaml; its Path is the name of the executable, resolved by resolveCommand.
+func newCommandTemplate(command domains.YAMLDomainTrafficRunCommand) trust.Command {
+	return trust.Command{
+		Path: filepath.Clean(pattern.ReplaceParameters(command.Run[0])),
+		Args: command.Run[1:],
+		Env:  command.Env,
+	}
+}
+
+// resolveCommand resolves the executable of the command to its absolute path; executables built from matches or variables cannot be resolved.
+func resolveCommand(template trust.Command) (trust.Command, error) {
+	if matchReferenceRegexp.MatchString(template.Path) {
+		return template, fmt.Errorf("%s is built from matches or variables and cannot be reviewed", template.Path)
+	}
+
+	path, err := trust.ResolveExecutable(template.Path)
+	if err != nil {
+		return template, err
+	}
+
+	template.Path = path
+	return template, nil
+}
+
+// checkCommandTrust ensures the commands are enabled and the command, with its executable as it is now, was allowed by the user;
+// the path of the checked executable is returned.
+func checkCommandTrust(template trust.Command) (string, error) {
+	if !areCommandsEnabled() {
+		return "", fmt.Errorf("commands are disabled (options.commands.enabled)")
+	}
+
+	command, err := resolveCommand(template)
+	if err != nil {
+		return "", err
+	}
+
+	if !trust.IsAllowed(command) {
+		return "", fmt.Errorf("%s is not allowed; restart InfiniteMITM to review it (see %s)", command.String(), trust.TrustFilename)
+	}
+
+	return command.Path, nil
+}
+
+// GetUnreviewedCommands returns the commands of the mitm.yaml which were never reviewed, changed or whose executable was modified since,
+// and the commands which cannot be reviewed (e.g., executables built from matches or not found).
+func GetUnreviewedCommands(content mitm.YAML) ([]trust.Command, []string) {
+	resolved, unresolved := collectCommands(content)
+
+	var unreviewed []trust.Command
+	for _, command := range resolved {
+		if !trust.IsReviewed(command) {
+			unreviewed = append(unreviewed, command)
+		}
+	}
+
+	return unreviewed, unresolved
+}
+
+// reportBlockedCommands warns about the commands which won't run, until they are reviewed.
+func reportBlockedCommands(content mitm.YAML) {
+	if !content.Options.Commands.IsEnabled() {
+		return
+	}
+
+	resolved, unresolved := collectCommands(content)
+	blocked := len(unresolved)
+	for _, command := range resolved {
+		if !trust.IsAllowed(command) {
+			blocked++
+		}
+	}
+
+	if blocked != 0 {
+		event.MustFire(eventsService.ProxyStatusMessage, event.M{
+			"details": fmt.Sprintf("[%s] %d command(s) not allowed; restart InfiniteMITM to review them (see %s)", mitm.MITMFilename, blocked, trust.TrustFilename),
+		})
+	}
+}
+
+// collectCommands returns every rule command, with its executable resolved to its absolute path when possible.
+func collectCommands(content mitm.YAML) ([]trust.Command, []string) {
+	customDomains, _ := domains.ParseCustomDomains(content.CustomDomains)
+	resolvedSet := map[string]trust.Command{}
+	unresolvedSet := map[string]bool{}
+
+	for _, pair := range domains.GetYAMLContentDomainPairs(content.Domains, customDomains) {
+		for _, node := range pair.Content {
+			for _, stage := range []domains.YAMLDomainTrafficCommands{node.Request.Before, node.Response.Before, node.Response.After} {
+				for _, command := range stage.Commands {
+					if len(command.Run) == 0 {
+						continue
+					}
+
+					template := newCommandTemplate(command)
+					if resolved, err := resolveCommand(template); err != nil {
+						unresolvedSet[template.String()] = true
+					} else {
+						resolvedSet[resolved.String()] = resolved
+					}
+				}
+			}
+		}
+	}
+
+	lines := make([]string, 0, len(resolvedSet))
+	for line := range resolvedSet {
+		lines = append(lines, line)
+	}
+
+	sort.Strings(lines)
+
+	resolved := make([]trust.Command, 0, len(lines))
+	for _, line := range lines {
+		resolved = append(resolved, resolvedSet[line])
+	}
+
+	unresolved := make([]string, 0, len(unresolvedSet))
+	for line := range unresolvedSet {
+		unresolved = append(unresolved, line)
+	}
+
+	sort.Strings(unresolved)
+	return resolved, unresolved
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMServiceHandlers
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+type bodyCapture struct {
+	Preview  []byte
+	Body     []byte
+	Size     int64
+	Complete bool
+}
+
+// captureReadCloser streams the body to its reader while keeping a size-capped
+// preview for the UI and, when requested, a full copy for the SmartCache.
+type captureReadCloser struct {
+	source  io.ReadCloser
+	preview bytes.Buffer
+	full    *bytes.Buffer
+	limit   int64
+	size    int64
+	eof     bool
+	once    sync.Once
+	onDone  func(capture bodyCapture)
+}
+
+func newCaptureReadCloser(source io.ReadCloser, limit int64, keepFull bool, onDone func(capture bodyCapture)) *captureReadCloser {
+	c := &captureReadCloser{
+		source: source,
+		limit:  limit,
+		onDone: onDone,
+	}
+
+	if keepFull {
+		c.full = &bytes.Buffer{}
+	}
+
+	return c
+}
+
+func (c *captureReadCloser) Read(p []byte) (int, error) {
+	n, err := c.source.Read(p)
+	if n > 0 {
+		if remaining := c.limit - int64(c.preview.Len()); remaining > 0 {
+			if int64(n) < remaining {
+				remaining = int64(n)
+			}
+
+			c.preview.Write(p[:remaining])
+		}
+
+		if c.full != nil {
+			c.full.Write(p[:n])
+		}
+
+		c.size += int64(n)
+	}
+
+	if err == io.EOF {
+		c.eof = true
+		c.done()
+	}
+
+	return n, err
+}
+
+func (c *captureReadCloser) Close() error {
+	err := c.source.Close()
+	c.done()
+	return err
+}
+
+// drain reads up to the capture limit so the preview is available even when the body is never forwarded.
+func (c *captureReadCloser) drain() {
+	io.CopyN(io.Discard, c, c.limit)
+	c.Close()
+}
+
+func (c *captureReadCloser) done() {
+	c.once.Do(func() {
+		capture := bodyCapture{
+			Preview:  c.preview.Bytes(),
+			Size:     c.size,
+			Complete: c.eof,
+		}
+
+		if c.full != nil {
+			capture.Body = c.full.Bytes()
+		}
+
+		c.onDone(capture)
+	})
+}
@@ -16,21 +16,27 @@ package MITMApplicationMITMServiceHandlers
 
 import (
 	"net/http"
+	"strings"
 
+	eventsService "infinite-mitm/internal/application/services/events"
+	helpers "infinite-mitm/internal/application/services/mitm/helpers"
 	context "infinite-mitm/internal/application/services/mitm/modules/context"
+	"infinite-mitm/pkg/request"
 	"infinite-mitm/pkg/smartcache"
 
 	"github.com/elazarl/goproxy"
 )
 
 type RequestHandlerStruct struct {
-	Match goproxy.ReqConditionFunc
-	Fn    func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response)
+	Target helpers.RuleTarget
+	Match  goproxy.ReqConditionFunc
+	Fn     func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response)
 }
 
 type ResponseHandlerStruct struct {
-	Match goproxy.RespConditionFunc
-	Fn    func(resp *http.Response, ctx *goproxy.ProxyCtx) *http.Response
+	Target helpers.RuleTarget
+	Match  goproxy.RespConditionFunc
+	Fn     func(resp *http.Response, ctx *goproxy.ProxyCtx) *http.Response
 }
 
 func getUUID(customCtx *context.CustomProxyCtx) string {
@@ -49,6 +55,46 @@ func getSmartCache(customCtx *context.CustomProxyCtx) *smartcache.SmartCache {
 	return smartCache
 }
 
+func getSmartCacheFlight(customCtx *context.CustomProxyCtx) *smartcache.SmartCacheFlight {
+	var flight *smartcache.SmartCacheFlight
+
+	flightCtx := customCtx.GetUserData(context.FlightKey)
+	if flightCtx != nil {
+		flight = flightCtx.(*smartcache.SmartCacheFlight)
+	}
+
+	return flight
+}
+
+func createSmartCacheKey(smartCache *smartcache.SmartCache, req *http.Request) string {
+	return smartCache.CreateKey(
+		request.StripPort(req.URL.String()),
+		req.Header.Get("Accept"),
+		req.Header.Get("Accept-Language"),
+	)
+}
+
+func landSmartCacheFlight(customCtx *context.CustomProxyCtx, item *smartcache.SmartCacheItem) {
+	if flight := getSmartCacheFlight(customCtx); flight != nil {
+		flight.Land(item)
+		customCtx.UnsetUserData(context.FlightKey)
+	}
+}
+
+// getCommandResults returns the results of the commands run for the given stage ("request" or "response").
+func getCommandResults(customCtx *context.CustomProxyCtx, stage string) []eventsService.CommandResult {
+	results, _ := customCtx.GetUserData(context.CommandsKey).([]eventsService.CommandResult)
+
+	var stageResults []eventsService.CommandResult
+	for _, result := range results {
+		if strings.HasPrefix(result.Stage, stage + ".") {
+			stageResults = append(stageResults, result)
+		}
+	}
+
+	return stageResults
+}
+
 func isRequestProxified(customCtx *context.CustomProxyCtx) bool {
 	proxified := customCtx.GetUserData(context.ProxyKey).(map[string]bool)
 	return proxified["req"]
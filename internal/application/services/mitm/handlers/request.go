@@ -17,6 +17,7 @@ package MITMApplicationMITMServiceHandlers
 import (
 	"bytes"
 	eventsService "infinite-mitm/internal/application/services/events"
+	helpers "infinite-mitm/internal/application/services/mitm/helpers"
 	context "infinite-mitm/internal/application/services/mitm/modules/context"
 	"infinite-mitm/pkg/mitm"
 	"infinite-mitm/pkg/request"
@@ -51,18 +52,34 @@ func HandleRequest(options mitm.TrafficOptions, req *http.Request, resp *http.Re
 	var smartCachedItem *smartcache.SmartCacheItem
 
 	if !isProxified && smartCache != nil {
-		smartCachedItem = smartCache.Get(smartCache.CreateKey(
-			request.StripPort(req.URL.String()),
-			req.Header.Get("Accept"),
-			req.Header.Get("Accept-Language"),
-		))
+		smartCache.Observe(req)
+		smartCacheKey := createSmartCacheKey(smartCache, req)
+		smartCachedItem = smartCache.Get(smartCacheKey)
+
+		if smartCachedItem == nil {
+			// concurrent identical requests wait for the first one instead of hitting upstream
+			flight, leader := smartCache.Join(smartCacheKey)
+			if leader {
+				customCtx.SetUserData(context.FlightKey, flight)
+				ctx.RoundTripper = goproxy.RoundTripperFunc(func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Response, error) {
+					resp, err := ctx.Proxy.Tr.RoundTrip(req)
+					if err != nil {
+						flight.Land(nil)
+					}
+
+					return resp, err
+				})
+			} else {
+				smartCachedItem = flight.Wait()
+			}
+		}
 
 		if smartCachedItem != nil {
 			resp = &http.Response{
 				Request: req,
 				StatusCode: http.StatusOK,
 				Status: http.StatusText(http.StatusOK),
-				Header: smartCachedItem.Header,
+				Header: smartCachedItem.Header.Clone(),
 				Body: io.NopCloser(bytes.NewReader(smartCachedItem.Body)),
 			}
 		}
@@ -78,26 +95,42 @@ func HandleRequest(options mitm.TrafficOptions, req *http.Request, resp *http.Re
 		options.TrafficDisplay == mitm.TrafficSmartCache && !isResponseProxified(customCtx) && smartCache != nil)
 
 	if shouldDispatch {
-		var bodyBytes []byte
-		if req.Body != nil {
-			bodyBytes, _ = io.ReadAll(req.Body)
-			req.Body.Close()
+		headersMap := request.HeadersToMap(req.Header)
+		client := helpers.GetClient(ctx)
+		dispatch := func(capture bodyCapture) {
+			bodySize := capture.Size
+			if req.ContentLength > bodySize {
+				bodySize = req.ContentLength
+			}
+
+			event.MustFire(eventsService.ProxyRequestSent, event.M{
+				eventsService.PayloadKey: eventsService.ProxyRequestEventData{
+					ID: uuid,
+					URL: req.URL.String(),
+					Method: req.Method,
+					Headers: headersMap,
+					Body: capture.Preview,
+					BodySize: bodySize,
+					Proxified: isProxified,
+					SmartCached: !isProxified && smartCache != nil,
+					Commands: getCommandResults(customCtx, "request"),
+					ClientAddress: client.Address,
+					ClientName: client.Name,
+				},
+			})
 		}
 
-		headersMap := request.HeadersToMap(req.Header)
-		event.MustFire(eventsService.ProxyRequestSent, event.M{
-			eventsService.PayloadKey: eventsService.ProxyRequestEventData{
-				ID: uuid,
-				URL: req.URL.String(),
-				Method: req.Method,
-				Headers: headersMap,
-				Body: bodyBytes,
-				Proxified: isProxified,
-				SmartCached: !isProxified && smartCache != nil,
-			},
-		})
-
-		req.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+		if req.Body == nil || req.Body == http.NoBody {
+			dispatch(bodyCapture{Complete: true})
+		} else {
+			captureBody := newCaptureReadCloser(req.Body, options.CaptureLimit, false, dispatch)
+			req.Body = captureBody
+
+			// the body won't be forwarded when the response is already known
+			if resp != nil {
+				captureBody.drain()
+			}
+		}
 	}
 
 	return req, resp
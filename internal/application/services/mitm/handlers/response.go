@@ -17,6 +17,7 @@ package MITMApplicationMITMServiceHandlers
 import (
 	"bytes"
 	eventsService "infinite-mitm/internal/application/services/events"
+	helpers "infinite-mitm/internal/application/services/mitm/helpers"
 	context "infinite-mitm/internal/application/services/mitm/modules/context"
 	"infinite-mitm/pkg/mitm"
 	"infinite-mitm/pkg/request"
@@ -60,12 +61,7 @@ func HandleResponse(options mitm.TrafficOptions, resp *http.Response, ctx *gopro
 	var smartCacheKey string
 	var smartCachedItem *smartcache.SmartCacheItem
 	if trySmartCache {
-		smartCacheKey = smartCache.CreateKey(
-			request.StripPort(resp.Request.URL.String()),
-			resp.Request.Header.Get("Accept"),
-			resp.Request.Header.Get("Accept-Language"),
-		)
-
+		smartCacheKey = createSmartCacheKey(smartCache, resp.Request)
 		smartCachedItem = smartCache.Get(smartCacheKey)
 	}
 
@@ -80,48 +76,74 @@ func HandleResponse(options mitm.TrafficOptions, resp *http.Response, ctx *gopro
 		options.TrafficDisplay == mitm.TrafficOverrides && isProxified ||
 		options.TrafficDisplay == mitm.TrafficSmartCache && !isProxified && smartCache != nil)
 
-	needBody := smartCachedItem != nil || isSmartCachable || shouldDispatch
-
-	var bodyBytes []byte
 	if smartCachedItem != nil {
-		bodyBytes = smartCachedItem.Body
 		if resp.Body != nil {
 			io.Copy(io.Discard, resp.Body)
 			resp.Body.Close()
 		}
-	} else if needBody && resp.Body != nil {
-		bodyBytes, _ = io.ReadAll(resp.Body)
-		resp.Body.Close()
+
+		resp.Body = io.NopCloser(bytes.NewReader(smartCachedItem.Body))
+		landSmartCacheFlight(customCtx, smartCachedItem)
 	}
 
 	if isSmartCachable {
-		smartCache.Write(smartCacheKey, &smartcache.SmartCacheItem{
-			Body: bodyBytes,
-			Header: resp.Header,
-		})
-
 		resp.Header.Set(request.MITMCacheHeaderKey, request.MITMCacheHeaderMissValue)
 	}
 
-	if shouldDispatch {
-		headersMap := request.HeadersToMap(resp.Header)
-		event.MustFire(eventsService.ProxyResponseReceived, event.M{
-			eventsService.PayloadKey: eventsService.ProxyResponseEventData{
-				ID: uuid,
-				URL: resp.Request.URL.String(),
-				Method: resp.Request.Method,
-				Status: resp.StatusCode,
-				Headers: headersMap,
-				Body: bodyBytes,
-				Proxified: isProxified,
-				SmartCached: !isProxified && (isSmartCached || smartCache != nil),
-			},
-		})
+	if !isSmartCachable && !shouldDispatch {
+		landSmartCacheFlight(customCtx, nil)
+		return resp
 	}
 
-	if needBody {
-		resp.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	headersMap := request.HeadersToMap(resp.Header)
+	client := helpers.GetClient(ctx)
+	smartCacheHeader := resp.Header.Clone()
+	smartCacheHeader.Del(request.MITMCacheHeaderKey)
+
+	onDone := func(capture bodyCapture) {
+		if isSmartCachable && capture.Complete {
+			smartCacheItem := &smartcache.SmartCacheItem{
+				Body: capture.Body,
+				Header: smartCacheHeader,
+			}
+
+			smartCache.Write(smartCacheKey, smartCacheItem)
+			landSmartCacheFlight(customCtx, smartCacheItem)
+			smartCache.Prefetch(resp.Request, smartCacheHeader, capture.Body)
+		} else {
+			landSmartCacheFlight(customCtx, nil)
+		}
+
+		if shouldDispatch {
+			bodySize := capture.Size
+			if resp.ContentLength > bodySize {
+				bodySize = resp.ContentLength
+			}
+
+			event.MustFire(eventsService.ProxyResponseReceived, event.M{
+				eventsService.PayloadKey: eventsService.ProxyResponseEventData{
+					ID: uuid,
+					URL: resp.Request.URL.String(),
+					Method: resp.Request.Method,
+					Status: resp.StatusCode,
+					Headers: headersMap,
+					Body: capture.Preview,
+					BodySize: bodySize,
+					Proxified: isProxified,
+					SmartCached: !isProxified && (isSmartCached || smartCache != nil),
+					Commands: getCommandResults(customCtx, "response"),
+					ClientAddress: client.Address,
+					ClientName: client.Name,
+				},
+			})
+		}
+	}
+
+	if resp.Body == nil || resp.Body == http.NoBody {
+		onDone(bodyCapture{Complete: true})
+		return resp
 	}
 
+	resp.Body = newCaptureReadCloser(resp.Body, options.CaptureLimit, isSmartCachable, onDone)
 	return resp
 }
@@ -0,0 +1,159 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMServiceHelpers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Client identifies the device which sent a request through the proxy.
+type Client struct {
+	Address string
+	Name    string
+}
+
+// ClientDirectory resolves client addresses to the device names declared in the "server.clients" option.
+type ClientDirectory struct {
+	names map[string]string
+}
+
+// ClientScope restricts a rule to some clients, by device name, IP address or CIDR range.
+type ClientScope struct {
+	names    map[string]bool
+	networks []*net.IPNet
+}
+
+func NewClientDirectory(names map[string]string) *ClientDirectory {
+	directory := &ClientDirectory{names: map[string]string{}}
+	for address, name := range names {
+		if ip := net.ParseIP(strings.TrimSpace(address)); ip != nil {
+			directory.names[ip.String()] = name
+		}
+	}
+
+	return directory
+}
+
+func (d *ClientDirectory) Identify(req *http.Request) Client {
+	ip := RemoteIP(req)
+	if ip == nil {
+		return Client{Address: req.RemoteAddr}
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	address := ip.String()
+	return Client{Address: address, Name: d.names[address]}
+}
+
+// Label returns the device name when known, otherwise its address.
+func (c Client) Label() string {
+	if c.Name != "" {
+		return c.Name
+	}
+
+	return c.Address
+}
+
+// NewClientScope returns nil, which matches every client, when entries is empty.
+func NewClientScope(entries []string) (*ClientScope, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	scope := &ClientScope{names: map[string]bool{}}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") || net.ParseIP(entry) != nil {
+			network, err := ParseNetwork(entry)
+			if err != nil {
+				return nil, err
+			}
+
+			scope.networks = append(scope.networks, network)
+			continue
+		}
+
+		scope.names[strings.ToLower(entry)] = true
+	}
+
+	return scope, nil
+}
+
+func (s *ClientScope) Matches(client Client) bool {
+	if s == nil {
+		return true
+	}
+
+	if client.Name != "" && s.names[strings.ToLower(client.Name)] {
+		return true
+	}
+
+	ip := net.ParseIP(client.Address)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range s.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func RemoteIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
+
+// ParseNetwork parses an IP address (e.g., "192.168.1.42") or a CIDR range (e.g., "192.168.1.0/24").
+func ParseNetwork(entry string) (*net.IPNet, error) {
+	entry = strings.TrimSpace(entry)
+	if strings.Contains(entry, "/") {
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		return network, nil
+	}
+
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address or CIDR: %s", entry)
+	}
+
+	bits := 32
+	if ip.To4() == nil {
+		bits = 128
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
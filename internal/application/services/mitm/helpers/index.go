@@ -0,0 +1,145 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMServiceHelpers
+
+import (
+	"infinite-mitm/pkg/pattern"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type RuleTarget struct {
+	Domain string
+	Path   string
+}
+
+// RuleIndex narrows down the rules that may match a request before running their regexes.
+// A rule can only match when its domain ends the request hostname and its literal path
+// prefix starts the path, or when its domain appears somewhere in the path or query.
+type RuleIndex struct {
+	domains   []*domainRules
+	hostCache sync.Map
+}
+
+type domainRules struct {
+	domain    string
+	prefixes  []*prefixRules
+	positions []int
+}
+
+type prefixRules struct {
+	prefix    string
+	positions []int
+}
+
+func NewRuleIndex(targets []RuleTarget) *RuleIndex {
+	index := &RuleIndex{}
+	byDomain := map[string]*domainRules{}
+
+	for position, target := range targets {
+		domain := strings.ToLower(target.Domain)
+		rules, exists := byDomain[domain]
+		if !exists {
+			rules = &domainRules{domain: domain}
+			byDomain[domain] = rules
+			index.domains = append(index.domains, rules)
+		}
+
+		rules.positions = append(rules.positions, position)
+		prefix := strings.ToLower(pattern.LiteralPrefix(target.Path))
+
+		var group *prefixRules
+		for _, p := range rules.prefixes {
+			if p.prefix == prefix {
+				group = p
+				break
+			}
+		}
+
+		if group == nil {
+			group = &prefixRules{prefix: prefix}
+			rules.prefixes = append(rules.prefixes, group)
+		}
+
+		group.positions = append(group.positions, position)
+	}
+
+	return index
+}
+
+// Candidates returns, in their original order, the positions of the rules worth matching against req.
+func (i *RuleIndex) Candidates(req *http.Request) []int {
+	if len(i.domains) == 0 {
+		return nil
+	}
+
+	hostname := strings.ToLower(req.URL.Hostname())
+	rest := strings.ToLower(req.URL.Path)
+	if req.URL.RawQuery != "" {
+		rest += "?" + strings.ToLower(req.URL.RawQuery)
+	}
+
+	var positions []int
+	for _, rules := range i.hostRules(hostname) {
+		for _, group := range rules.prefixes {
+			if strings.HasPrefix(rest, group.prefix) {
+				positions = append(positions, group.positions...)
+			}
+		}
+	}
+
+	for _, rules := range i.domains {
+		if strings.Contains(rest, rules.domain) {
+			positions = append(positions, rules.positions...)
+		}
+	}
+
+	if len(positions) < 2 {
+		return positions
+	}
+
+	sort.Ints(positions)
+	unique := positions[:1]
+	for _, position := range positions[1:] {
+		if position != unique[len(unique) - 1] {
+			unique = append(unique, position)
+		}
+	}
+
+	return unique
+}
+
+func (i *RuleIndex) hostRules(hostname string) []*domainRules {
+	if cached, ok := i.hostCache.Load(hostname); ok {
+		return cached.([]*domainRules)
+	}
+
+	var matches []*domainRules
+	for _, rules := range i.domains {
+		if strings.HasSuffix(hostname, rules.domain) {
+			matches = append(matches, rules)
+		}
+	}
+
+	i.hostCache.Store(hostname, matches)
+	return matches
+}
+
+// HasHost reports whether at least one rule may match a request sent to hostname.
+func (i *RuleIndex) HasHost(hostname string) bool {
+	return len(i.hostRules(strings.ToLower(hostname))) > 0
+}
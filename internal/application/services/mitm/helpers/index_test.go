@@ -0,0 +1,86 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMServiceHelpers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRuleIndexCandidates(t *testing.T) {
+	index := NewRuleIndex([]RuleTarget{
+		{Domain: "settings.svc.halowaypoint.com", Path: "/hipc/:guid"},
+		{Domain: "blobs-infiniteugc.svc.halowaypoint.com", Path: "/ugcstorage/map/*"},
+		{Domain: "settings.svc.halowaypoint.com", Path: "/hipc/spartan"},
+		{Domain: "SETTINGS.svc.halowaypoint.com", Path: "/oban/flight-configurations"},
+		{Domain: "settings.svc.halowaypoint.com", Path: "$"},
+		{Domain: "settings.svc.halowaypoint.com", Path: "/hipc/:guid"},
+	})
+
+	tests := []struct {
+		name string
+		url  string
+		want []int
+	}{
+		{"matching prefixes keep their original order", "https://settings.svc.halowaypoint.com/hipc/spartan", []int{0, 2, 4, 5}},
+		{"prefixes are case-insensitive", "https://settings.svc.halowaypoint.com/OBAN/flight-configurations", []int{3, 4}},
+		{"only the catch-all path matches other paths", "https://settings.svc.halowaypoint.com/other", []int{4}},
+		{"other domains are not candidates", "https://blobs-infiniteugc.svc.halowaypoint.com/ugcstorage/map/1", []int{1}},
+		{"a domain within the path is deduplicated", "https://settings.svc.halowaypoint.com/hipc/settings.svc.halowaypoint.com", []int{0, 2, 3, 4, 5}},
+		{"a domain within the query is a candidate", "https://example.com/redirect?to=blobs-infiniteugc.svc.halowaypoint.com", []int{1}},
+		{"unknown hosts have no candidates", "https://example.com/hipc/spartan", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, test.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := index.Candidates(req); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Candidates(%s) = %v, want %v", test.url, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRuleIndexHasHost(t *testing.T) {
+	index := NewRuleIndex([]RuleTarget{{Domain: ".svc.halowaypoint.com", Path: "/"}})
+
+	tests := []struct {
+		hostname string
+		want     bool
+	}{
+		{"settings.svc.halowaypoint.com", true},
+		{"Settings.SVC.halowaypoint.com", true},
+		{"halowaypoint.com", false},
+		{"example.com", false},
+	}
+
+	for _, test := range tests {
+		if got := index.HasHost(test.hostname); got != test.want {
+			t.Errorf("HasHost(%s) = %t, want %t", test.hostname, got, test.want)
+		}
+	}
+}
+
+func TestRuleIndexEmpty(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://settings.svc.halowaypoint.com/", nil)
+	if got := NewRuleIndex(nil).Candidates(req); got != nil {
+		t.Errorf("Candidates() = %v, want nil", got)
+	}
+}
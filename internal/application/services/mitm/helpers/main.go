@@ -15,24 +15,33 @@
 package MITMApplicationMITMServiceHelpers
 
 import (
+	context "infinite-mitm/internal/application/services/mitm/modules/context"
 	"net/http"
 	"regexp"
 
 	"github.com/elazarl/goproxy"
 )
 
-func MatchRequestURL(re *regexp.Regexp) goproxy.ReqConditionFunc {
+func MatchRequestURL(re *regexp.Regexp, scope *ClientScope) goproxy.ReqConditionFunc {
 	return func(req *http.Request, ctx *goproxy.ProxyCtx) bool {
-		return MatchURL(req, re)
+		return MatchURL(req, re) && (scope == nil || scope.Matches(GetClient(ctx)))
 	}
 }
 
-func MatchResponseURL(re *regexp.Regexp) goproxy.RespConditionFunc {
+func MatchResponseURL(re *regexp.Regexp, scope *ClientScope) goproxy.RespConditionFunc {
 	return func(resp *http.Response, ctx *goproxy.ProxyCtx) bool {
-		return MatchURL(resp.Request, re)
+		return MatchURL(resp.Request, re) && (scope == nil || scope.Matches(GetClient(ctx)))
 	}
 }
 
+func GetClient(ctx *goproxy.ProxyCtx) Client {
+	if client, ok := context.ContextHandler(ctx).GetUserData(context.ClientKey).(Client); ok {
+		return client
+	}
+
+	return Client{}
+}
+
 func MatchURL(req *http.Request, re *regexp.Regexp) bool {
 	url := req.URL.Hostname() + req.URL.Path
 	query := req.URL.RawQuery
@@ -0,0 +1,67 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMServiceHelpers
+
+import (
+	"strings"
+)
+
+// InterceptHosts matches hostnames against the "intercept_hosts" entries of the mitm.yaml:
+// "*" matches every host, "*.example.com" any subdomain and anything else the exact hostname.
+type InterceptHosts struct {
+	all      bool
+	exact    map[string]bool
+	suffixes []string
+}
+
+func NewInterceptHosts(patterns []string) *InterceptHosts {
+	hosts := &InterceptHosts{exact: map[string]bool{}}
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+
+		if pattern == "*" {
+			hosts.all = true
+		} else if strings.HasPrefix(pattern, "*.") {
+			hosts.suffixes = append(hosts.suffixes, pattern[1:])
+		} else {
+			hosts.exact[pattern] = true
+		}
+	}
+
+	return hosts
+}
+
+func (h *InterceptHosts) Matches(hostname string) bool {
+	if h.all {
+		return true
+	}
+
+	hostname = strings.ToLower(hostname)
+	if h.exact[hostname] {
+		return true
+	}
+
+	for _, suffix := range h.suffixes {
+		if strings.HasSuffix(hostname, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
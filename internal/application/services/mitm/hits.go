@@ -0,0 +1,305 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"fmt"
+	eventsService "infinite-mitm/internal/application/services/events"
+	context "infinite-mitm/internal/application/services/mitm/modules/context"
+	"infinite-mitm/pkg/domains"
+	"infinite-mitm/pkg/errors"
+	"infinite-mitm/pkg/pattern"
+	"infinite-mitm/pkg/request"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elazarl/goproxy"
+	"github.com/gookit/event"
+)
+
+// ruleState counts the hits of a rule, to pick its response variant and expire it.
+type ruleState struct {
+	label       string
+	maxHits     int64
+	window      time.Duration
+	variants    []domains.YAMLDomainResponseVariant
+	random      bool
+	loop        bool
+	totalWeight int
+	target      *regexp.Regexp
+	scenario    *scenarioRule
+
+	mutex    sync.Mutex
+	hits     int64
+	firstHit time.Time
+}
+
+// ruleHit is a request matched by a rule; variant is -1 when the rule has no variants, or when its sequence is over.
+type ruleHit struct {
+	variant   int
+	responded bool
+}
+
+// states of the rules of the running proxy server, replaced when the handlers are created again
+var (
+	ruleStates      []*ruleState
+	ruleStatesMutex sync.Mutex
+)
+
+func resetRuleStates() {
+	ruleStatesMutex.Lock()
+	defer ruleStatesMutex.Unlock()
+
+	ruleStates = nil
+}
+
+// newRuleState registers the hit counter of a rule; invalid "expire" and "variants" options are reported then ignored.
+func newRuleState(domain domains.DomainType, node domains.YAMLDomainNode, scenario *scenarioRule) *ruleState {
+	label := domains.DomainToHostname(domain) + node.Path
+	if strings.HasPrefix(label, ".") {
+		label = "*" + label
+	}
+
+	state := &ruleState{
+		label:    label,
+		maxHits:  node.Expire.Hits,
+		loop:     node.Response.Loop,
+		target:   pattern.Create(domain, node.Path),
+		scenario: scenario,
+	}
+
+	if node.Expire.Window != "" {
+		window, err := time.ParseDuration(node.Expire.Window)
+		if err != nil || window <= 0 {
+			reportRuleError(node.Path, "expire", fmt.Sprintf("invalid window: %s", node.Expire.Window))
+		} else {
+			state.window = window
+		}
+	}
+
+	switch strings.ToLower(node.Response.Order) {
+	case "", domains.VariantsSequence:
+	case domains.VariantsRandom:
+		state.random = true
+	default:
+		reportRuleError(node.Path, "variants", fmt.Sprintf("unsupported order: %s (expected sequence or random)", node.Response.Order))
+	}
+
+	for _, variant := range node.Response.Variants {
+		if variant.Weight < 0 {
+			reportRuleError(node.Path, "variants", fmt.Sprintf("invalid weight: %d", variant.Weight))
+			variant.Weight = 0
+		} else if variant.Weight == 0 {
+			variant.Weight = 1
+		}
+
+		state.variants = append(state.variants, variant)
+		state.totalWeight += variant.Weight
+	}
+
+	ruleStatesMutex.Lock()
+	ruleStates = append(ruleStates, state)
+	ruleStatesMutex.Unlock()
+
+	return state
+}
+
+// active reports whether the rule may match the request; a request already counted keeps matching,
+// so the response handler still runs after the hit which expired the rule, or changed its scenario.
+func (s *ruleState) active(ctx *goproxy.ProxyCtx) bool {
+	if customCtx, ok := ctx.UserData.(*context.CustomProxyCtx); ok {
+		hits, _ := customCtx.GetUserData(context.HitsKey).(map[*ruleState]*ruleHit)
+		if _, ok := hits[s]; ok {
+			return true
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return !s.isExpired() && s.scenario.allows()
+}
+
+func (s *ruleState) isExpired() bool {
+	if s.maxHits > 0 && s.hits >= s.maxHits {
+		return true
+	}
+
+	return s.window > 0 && !s.firstHit.IsZero() && time.Since(s.firstHit) > s.window
+}
+
+// hit counts the request once, even when both the request and response handlers of the rule run for it,
+// and fires the scenario of the rule; nil is returned when the rule has expired, or its scenario has changed.
+func (s *ruleState) hit(customCtx *context.CustomProxyCtx) *ruleHit {
+	hits, _ := customCtx.GetUserData(context.HitsKey).(map[*ruleState]*ruleHit)
+	if hit, ok := hits[s]; ok {
+		return hit
+	}
+
+	var matches []string
+	if s.scenario.transitions() {
+		matches = pattern.Match(s.target, request.StripPort(customCtx.Req.URL.String()))
+	}
+
+	s.mutex.Lock()
+	if s.isExpired() || !s.scenario.fire(matches) {
+		s.mutex.Unlock()
+		return nil
+	}
+
+	s.hits++
+	if s.firstHit.IsZero() {
+		s.firstHit = time.Now()
+	}
+
+	hit := &ruleHit{variant: s.pickVariant()}
+	s.mutex.Unlock()
+
+	if hits == nil {
+		hits = map[*ruleState]*ruleHit{}
+		customCtx.SetUserData(context.HitsKey, hits)
+	}
+
+	hits[s] = hit
+	return hit
+}
+
+func (s *ruleState) pickVariant() int {
+	if len(s.variants) == 0 {
+		return -1
+	}
+
+	if s.random {
+		if s.totalWeight == 0 {
+			return -1
+		}
+
+		draw := rand.Intn(s.totalWeight)
+		for i, variant := range s.variants {
+			if draw < variant.Weight {
+				return i
+			}
+
+			draw -= variant.Weight
+		}
+	}
+
+	position := int(s.hits - 1)
+	if position >= len(s.variants) {
+		if !s.loop {
+			return -1
+		}
+
+		position %= len(s.variants)
+	}
+
+	return position
+}
+
+// response returns the response of the rule for the hit, with its variant applied; false is returned
+// when the sequence of variants is over, and the response comes from the service untouched.
+func (h *ruleHit) response(state *ruleState, response domains.YAMLDomainResponseNode) (domains.YAMLDomainResponseNode, bool) {
+	if len(state.variants) == 0 {
+		return response, true
+	}
+
+	if h.variant < 0 {
+		return domains.YAMLDomainResponseNode{}, false
+	}
+
+	variant := state.variants[h.variant]
+	if variant.Body != "" {
+		response.Body = variant.Body
+	}
+
+	if variant.BodyTemplate != "" {
+		response.BodyTemplate = variant.BodyTemplate
+	}
+
+	if variant.StatusCode != 0 {
+		response.StatusCode = variant.StatusCode
+	}
+
+	if len(variant.Headers) != 0 {
+		headers := make(map[string]string, len(response.Headers) + len(variant.Headers))
+		for key, value := range response.Headers {
+			headers[key] = value
+		}
+
+		for key, value := range variant.Headers {
+			headers[key] = value
+		}
+
+		response.Headers = headers
+	}
+
+	return response, true
+}
+
+// ReportRuleHits displays the hit counters of the rules in the status bar.
+func ReportRuleHits() {
+	ruleStatesMutex.Lock()
+	states := append([]*ruleState{}, ruleStates...)
+	ruleStatesMutex.Unlock()
+
+	var counters []string
+	for _, state := range states {
+		state.mutex.Lock()
+		counter := fmt.Sprintf("%s %d", state.label, state.hits)
+		if state.maxHits > 0 {
+			counter += fmt.Sprintf("/%d", state.maxHits)
+		}
+
+		if state.isExpired() {
+			counter += " (expired)"
+		}
+
+		hits := state.hits
+		state.mutex.Unlock()
+
+		if hits != 0 {
+			counters = append(counters, counter)
+		}
+	}
+
+	details := "rule hits: none yet"
+	if len(counters) != 0 {
+		details = "rule hits: " + strings.Join(counters, " · ")
+	}
+
+	event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": details})
+}
+
+// ResetRuleHits restarts the sequences, hit limits and windows of every rule.
+func ResetRuleHits() {
+	ruleStatesMutex.Lock()
+	for _, state := range ruleStates {
+		state.mutex.Lock()
+		state.hits = 0
+		state.firstHit = time.Time{}
+		state.mutex.Unlock()
+	}
+	ruleStatesMutex.Unlock()
+
+	event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": "rule hits: reset"})
+}
+
+func reportRuleError(path string, option string, details string) {
+	mitmErr := errors.Create(errors.ErrYAMLReadException, fmt.Sprintf("invalid %s for %s; %s", option, path, details))
+	event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
+}
@@ -15,18 +15,21 @@
 package MITMApplicationMITMService
 
 import (
-	"crypto/tls"
-	"embed"
 	"fmt"
-	"infinite-mitm/configs"
 	eventsService "infinite-mitm/internal/application/services/events"
 	handlers "infinite-mitm/internal/application/services/mitm/handlers"
+	helpers "infinite-mitm/internal/application/services/mitm/helpers"
 	context "infinite-mitm/internal/application/services/mitm/modules/context"
+	"infinite-mitm/pkg/certificate"
 	"infinite-mitm/pkg/domains"
 	"infinite-mitm/pkg/errors"
 	"infinite-mitm/pkg/mitm"
+	"infinite-mitm/pkg/onboarding"
+	"infinite-mitm/pkg/pac"
+	"infinite-mitm/pkg/pattern"
 	"infinite-mitm/pkg/smartcache"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 	"time"
@@ -38,40 +41,54 @@ import (
 
 type emptyLogger struct{}
 
-var certName = configs.GetConfig().Proxy.Certificate.Name
 var smartCache *smartcache.SmartCache
 
-func CreateServer(f *embed.FS) (*http.Server, *errors.MITMError) {
-	CACert, err := f.ReadFile(fmt.Sprintf("cert/%s.pem", certName)); if err != nil {
-		return nil, errors.Create(errors.ErrProxyCertificateException, err.Error())
-	}
-
-	CAKey, err := f.ReadFile(fmt.Sprintf("cert/%s.key", certName)); if err != nil {
-		return nil, errors.Create(errors.ErrProxyCertificateException, err.Error())
-	}
-
-	cert, err := tls.X509KeyPair(CACert, CAKey); if err != nil {
-		return nil, errors.Create(errors.ErrProxyCertificateException, err.Error())
+func CreateServer() (*http.Server, *errors.MITMError) {
+	cert, mitmErr := certificate.Load(); if mitmErr != nil {
+		return nil, mitmErr
 	}
 
 	goproxy.GoproxyCa = cert
+	certStorage.UseCA(cert)
+
 	proxy := goproxy.NewProxyHttpServer()
+	proxy.CertStore = certStorage
 	proxy.Verbose = false
 	proxy.KeepHeader = false
 	proxy.Logger = emptyLogger{}
 
-	if proxy.Tr != nil {
-		proxy.Tr.MaxIdleConns = 100
-		proxy.Tr.MaxIdleConnsPerHost = 32
-		proxy.Tr.IdleConnTimeout = 90 * time.Second
-		proxy.Tr.ResponseHeaderTimeout = 30 * time.Second
-		proxy.Tr.ExpectContinueTimeout = 1 * time.Second
-	}
-
 	content, mitmErr := mitm.ReadClientMITMConfig(); if mitmErr != nil {
 		event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
 	}
 
+	// invalid options stop the server from starting, rather than letting the traffic reach the services without them
+	dialer, err := configureTransport(proxy, content.Options); if err != nil {
+		return nil, errors.Create(errors.ErrYAMLReadException, err.Error())
+	}
+
+	overrides, err := newUpstreamOverrides(content.Options.UpstreamOverride, proxy.Tr, dialer); if err != nil {
+		return nil, errors.Create(errors.ErrYAMLReadException, err.Error())
+	}
+
+	customDomains, err := domains.ParseCustomDomains(content.CustomDomains); if err != nil {
+		return nil, errors.Create(errors.ErrYAMLReadException, err.Error())
+	}
+
+	blocked, err := newBlocklist(content.Blocklist); if err != nil {
+		return nil, errors.Create(errors.ErrYAMLReadException, err.Error())
+	}
+
+	if err := setScenarios(content.Scenarios); err != nil {
+		return nil, errors.Create(errors.ErrYAMLReadException, err.Error())
+	}
+
+	pattern.SetCustomParameters(domains.GetCustomParameters(customDomains))
+	setUserVariables(content.Options.Variables)
+	resetBodyTemplates()
+	setScriptingOptions(content.Options.Scripting)
+	setCommandsOptions(content.Options.Commands)
+	interceptedHostnames := append(append([]string{}, content.Options.InterceptHosts...), domains.GetInterceptedHostnames(customDomains)...)
+
 	var clientRequestHandlers []handlers.RequestHandlerStruct
 	var clientResponseHandlers []handlers.ResponseHandlerStruct
 
@@ -79,7 +96,7 @@ func CreateServer(f *embed.FS) (*http.Server, *errors.MITMError) {
 	var totalRespProxy int
 
 	if mitmErr == nil {
-		clientRequestHandlers, clientResponseHandlers, totalReqProxy, totalRespProxy = CreateClientMITMHandlers(content)
+		clientRequestHandlers, clientResponseHandlers, totalReqProxy, totalRespProxy = CreateClientMITMHandlers(content, customDomains)
 		totalClientHandlersCount := totalReqProxy + totalRespProxy
 
 		domainText := "handler"
@@ -107,6 +124,10 @@ func CreateServer(f *embed.FS) (*http.Server, *errors.MITMError) {
 			default:
 				smartCacheText = "on"
 			}
+
+			if content.Options.SmartCache.Prefetch.Enabled {
+				smartCacheText += " + prefetch"
+			}
 		}
 
 		event.MustFire(eventsService.ProxyStatusMessage, event.M{
@@ -123,11 +144,18 @@ func CreateServer(f *embed.FS) (*http.Server, *errors.MITMError) {
 				responseText,
 			),
 		})
+
+		reportBlockedCommands(content)
 	}
 
 	smartCacheEnabled := content.Options.SmartCache.Enabled
 
 	if !smartCacheEnabled {
+		if smartCache != nil {
+			// stops the prefetch workers
+			smartCache.ConfigurePrefetch(smartcache.SmartCachePrefetchYAMLOptions{}, nil)
+		}
+
 		smartCache = nil
 	} else if smartCache == nil {
 		smartCache = smartcache.New(
@@ -136,16 +164,76 @@ func CreateServer(f *embed.FS) (*http.Server, *errors.MITMError) {
 		)
 	}
 
-	trafficOptions := mitm.TrafficOptions{TrafficDisplay: content.Options.TrafficDisplay}
-	mitmPattern := regexp.MustCompile(`^.*` + regexp.QuoteMeta(domains.HaloWaypointSVCDomains.Root)  + `(:[0-9]+)?$`)
-	rootCondition := goproxy.ReqHostMatches(mitmPattern)
+	if smartCache != nil {
+		smartCache.ConfigurePrefetch(content.Options.SmartCache.Prefetch, &prefetchTransport{transport: proxy.Tr, overrides: overrides})
+	}
+
+	requestIndex, responseIndex := createHandlersIndexes(clientRequestHandlers, clientResponseHandlers)
+	resetStats()
+
+	trafficOptions := mitm.TrafficOptions{
+		TrafficDisplay: content.Options.TrafficDisplay,
+		CaptureLimit: mitm.ParseCaptureLimit(content.Options.CaptureLimit),
+	}
+
+	// serves the PAC script and the onboarding page to direct requests (e.g., http://127.0.0.1:1337/proxy.pac)
+	pac.SetHosts(append(append([]string{}, interceptedHostnames...), blocked.Hosts()...))
+	nonproxyHandler := http.NewServeMux()
+	nonproxyHandler.HandleFunc(pac.Path, pac.Handler)
+	nonproxyHandler.HandleFunc("/", onboarding.Handler)
+	proxy.NonproxyHandler = nonproxyHandler
+
+	// serves the onboarding page to devices using the proxy (http://infinite.mitm)
+	proxy.OnRequest(goproxy.ReqConditionFunc(func(req *http.Request, ctx *goproxy.ProxyCtx) bool {
+		return onboarding.IsOnboardingRequest(req)
+	})).DoFunc(func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+		return req, onboarding.Response(req)
+	})
+
+	mitmPatterns := []*regexp.Regexp{regexp.MustCompile(`^.*` + regexp.QuoteMeta(domains.HaloWaypointSVCDomains.Root)  + `(:[0-9]+)?$`)}
+	for _, hostname := range domains.GetInterceptedHostnames(customDomains) {
+		mitmPatterns = append(mitmPatterns, regexp.MustCompile(`(?i)^` + regexp.QuoteMeta(hostname) + `(:[0-9]+)?$`))
+	}
+
+	for _, host := range blocked.Hosts() {
+		hostPattern := `^` + regexp.QuoteMeta(host)
+		if strings.HasPrefix(host, "*.") {
+			hostPattern = `^.*` + regexp.QuoteMeta(host[1:])
+		}
+
+		mitmPatterns = append(mitmPatterns, regexp.MustCompile(`(?i)` + hostPattern + `(:[0-9]+)?$`))
+	}
+
+	rootCondition := goproxy.ReqHostMatches(mitmPatterns...)
+
+	interceptHosts := helpers.NewInterceptHosts(interceptedHostnames)
+	clients := helpers.NewClientDirectory(content.Options.Server.Clients)
+	mitmConnect := &goproxy.ConnectAction{Action: goproxy.ConnectMitm, TLSConfig: goproxy.TLSConfigFromCA(&cert)}
+	tunnelConnect := &goproxy.ConnectAction{Action: goproxy.ConnectAccept}
+
+	proxy.OnRequest(rootCondition).HandleConnectFunc(func(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
+		hostname := ctx.Req.URL.Hostname()
+
+		// hosts without any rule, smart cache or explicit opt-in are tunnelled untouched
+		if trafficOptions.TrafficDisplay == mitm.TrafficAll ||
+			interceptHosts.Matches(hostname) ||
+			blocked.HasHost(hostname) ||
+			requestIndex.HasHost(hostname) ||
+			responseIndex.HasHost(hostname) ||
+			overrides.HasHost(hostname) ||
+			(smartCacheEnabled && smartcache.IsHostnameSmartCachable(hostname)) {
+			return mitmConnect, host
+		}
+
+		return tunnelConnect, host
+	})
 
-	proxy.OnRequest(rootCondition).HandleConnect(goproxy.AlwaysMitm)
 	proxy.OnRequest(rootCondition).DoFunc(func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
 		var resp *http.Response
 		customCtx := context.ContextHandler(ctx)
 		customCtx.SetUserData(context.IDKey, uuid.New().String())
 		customCtx.SetUserData(context.ProxyKey, map[string]bool{"req": false, "resp": false})
+		customCtx.SetUserData(context.ClientKey, clients.Identify(req))
 
 		if smartCacheEnabled && smartcache.IsRequestSmartCachable(req) {
 			customCtx.SetUserData(context.CacheKey, smartCache)
@@ -157,35 +245,91 @@ func CreateServer(f *embed.FS) (*http.Server, *errors.MITMError) {
 			req.Header.Del("343-Clearance")
 		}
 
-		for _, handler := range clientRequestHandlers {
-			if handler.Match(req, ctx) {
-				req, resp = handler.Fn(req, ctx)
-				return handlers.HandleRequest(trafficOptions, req, resp, ctx)
-			}
+		// blocked requests are answered by the proxy, before any rule
+		if block := blocked.Match(req); block != nil {
+			customCtx.GetUserData(context.ProxyKey).(map[string]bool)["req"] = true
+			customCtx.UnsetUserData(context.CacheKey)
+			resp = blockRequest(*block, req, ctx)
+		} else if handler := matchRequestHandler(clientRequestHandlers, requestIndex, req, ctx); handler != nil {
+			req, resp = handler.Fn(req, ctx)
+		}
+
+		// rewritten requests are sent to their new URL, through the upstream override of the new host if any
+		if target, ok := customCtx.GetUserData(context.RewriteKey).(*url.URL); ok && resp == nil && ctx.RoundTripper == nil {
+			ctx.RoundTripper = &requestRewrite{target: target, override: overrides.Match(target.Hostname())}
+		}
+
+		// forwarded requests are flagged as overridden, which also keeps them out of the SmartCache
+		if override := overrides.Match(req.URL.Hostname()); override != nil && resp == nil && ctx.RoundTripper == nil {
+			customCtx.GetUserData(context.ProxyKey).(map[string]bool)["req"] = true
+			ctx.RoundTripper = override
 		}
 
 		return handlers.HandleRequest(trafficOptions, req, resp, ctx)
 	})
 
 	proxy.OnResponse(rootCondition).DoFunc(func(resp *http.Response, ctx *goproxy.ProxyCtx) (*http.Response) {
-		for _, handler := range clientResponseHandlers {
-			if handler.Match(resp, ctx) {
-				resp = handler.Fn(resp, ctx)
-				return handlers.HandleResponse(trafficOptions, resp, ctx)
-			}
+		if handler := matchResponseHandler(clientResponseHandlers, responseIndex, resp, ctx); handler != nil {
+			resp = handler.Fn(resp, ctx)
 		}
 
 		return handlers.HandleResponse(trafficOptions, resp, ctx)
 	})
 
+	handler, err := newAccessHandler(proxy, content.Options.Server); if err != nil {
+		return nil, errors.Create(errors.ErrProxyServerException, err.Error())
+	}
+
 	server := &http.Server{
-		Addr: fmt.Sprintf(":%d", configs.GetConfig().Proxy.Port),
-		Handler: proxy,
+		Addr: getServerAddr(content.Options.Server),
+		Handler: handler,
 	}
 
 	return server, nil
 }
 
+func createHandlersIndexes(requestHandlers []handlers.RequestHandlerStruct, responseHandlers []handlers.ResponseHandlerStruct) (*helpers.RuleIndex, *helpers.RuleIndex) {
+	requestTargets := make([]helpers.RuleTarget, 0, len(requestHandlers))
+	for _, handler := range requestHandlers {
+		requestTargets = append(requestTargets, handler.Target)
+	}
+
+	responseTargets := make([]helpers.RuleTarget, 0, len(responseHandlers))
+	for _, handler := range responseHandlers {
+		responseTargets = append(responseTargets, handler.Target)
+	}
+
+	return helpers.NewRuleIndex(requestTargets), helpers.NewRuleIndex(responseTargets)
+}
+
+func matchRequestHandler(requestHandlers []handlers.RequestHandlerStruct, index *helpers.RuleIndex, req *http.Request, ctx *goproxy.ProxyCtx) *handlers.RequestHandlerStruct {
+	start := time.Now()
+	candidates := index.Candidates(req)
+	defer func() { stats.record(len(candidates), time.Since(start)) }()
+
+	for _, position := range candidates {
+		if requestHandlers[position].Match(req, ctx) {
+			return &requestHandlers[position]
+		}
+	}
+
+	return nil
+}
+
+func matchResponseHandler(responseHandlers []handlers.ResponseHandlerStruct, index *helpers.RuleIndex, resp *http.Response, ctx *goproxy.ProxyCtx) *handlers.ResponseHandlerStruct {
+	start := time.Now()
+	candidates := index.Candidates(resp.Request)
+	defer func() { stats.record(len(candidates), time.Since(start)) }()
+
+	for _, position := range candidates {
+		if responseHandlers[position].Match(resp, ctx) {
+			return &responseHandlers[position]
+		}
+	}
+
+	return nil
+}
+
 func (l emptyLogger) Printf(format string, v ...interface{}) {
 	// Ignore goproxy logs
 }
@@ -0,0 +1,558 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// Starlark cannot limit the memory of a thread, so the operations able to build large values in a single step
+// (e.g., "x" * (1 << 29)) are rewritten into calls to the builtins below, which check the size of the value
+// against the scripting max_memory option before building it; the values built element by element are bounded by max_steps.
+const (
+	scriptBinaryBuiltin    = "__mitm_binary__"
+	scriptAugmentedBuiltin = "__mitm_augmented__"
+	scriptMethodBuiltin    = "__mitm_method__"
+	// approximate size of a reference to a value held by a list, tuple, dict or set
+	scriptValueSlot = 16
+)
+
+var scriptGuardedOperators = map[string]syntax.Token{"+": syntax.PLUS, "*": syntax.STAR, "%": syntax.PERCENT, "|": syntax.PIPE}
+
+var scriptAugmentedOperators = map[syntax.Token]syntax.Token{
+	syntax.PLUS_EQ:    syntax.PLUS,
+	syntax.STAR_EQ:    syntax.STAR,
+	syntax.PERCENT_EQ: syntax.PERCENT,
+	syntax.PIPE_EQ:    syntax.PIPE,
+}
+
+var scriptGuardedMethods = map[string]bool{"extend": true, "format": true, "join": true, "replace": true, "rsplit": true, "split": true, "splitlines": true}
+
+var scriptGuardedBuiltins = []string{"enumerate", "list", "repr", "reversed", "set", "sorted", "str", "tuple", "zip"}
+
+// compileScript parses the script, guards the operations able to build large values and compiles it.
+func compileScript(path string, data []byte, isPredeclared func(string) bool) (*starlark.Program, error) {
+	file, err := scriptFileOptions.Parse(path, data, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := guardScriptFile(file); err != nil {
+		return nil, err
+	}
+
+	return starlark.FileProgram(file, isPredeclared)
+}
+
+// guardScriptFile rewrites every guarded expression of the file, which is walked after each of its nodes is rewritten.
+func guardScriptFile(file *syntax.File) error {
+	var err error
+	var guard func(node syntax.Node) bool
+	guard = func(node syntax.Node) bool {
+		if err != nil {
+			return false
+		}
+
+		switch n := node.(type) {
+		case *syntax.ExprStmt:
+			n.X = guardScriptExpr(n.X)
+		case *syntax.IfStmt:
+			n.Cond = guardScriptExpr(n.Cond)
+		case *syntax.WhileStmt:
+			// not walked by syntax.Walk
+			n.Cond = guardScriptExpr(n.Cond)
+			syntax.Walk(n.Cond, guard)
+			for _, stmt := range n.Body {
+				syntax.Walk(stmt, guard)
+			}
+
+			return false
+		case *syntax.ForStmt:
+			n.X = guardScriptExpr(n.X)
+		case *syntax.ReturnStmt:
+			n.Result = guardScriptExpr(n.Result)
+		case *syntax.AssignStmt:
+			n.RHS = guardScriptExpr(n.RHS)
+			if op, ok := scriptAugmentedOperators[n.Op]; ok {
+				// x += y is checked as x + y, the target being evaluated once more
+				target, ok := cloneScriptTarget(n.LHS)
+				if !ok {
+					start, _ := n.LHS.Span()
+					err = syntax.Error{Pos: start, Msg: fmt.Sprintf("%s is only supported on names, fields and indexes (use x = x %s y)", n.Op, op)}
+					return false
+				}
+
+				n.RHS = newScriptGuardCall(scriptAugmentedBuiltin, n.OpPos, newScriptString(op.String(), n.OpPos), target, n.RHS)
+			}
+		case *syntax.ListExpr:
+			guardScriptExprs(n.List)
+		case *syntax.TupleExpr:
+			guardScriptExprs(n.List)
+		case *syntax.ParenExpr:
+			n.X = guardScriptExpr(n.X)
+		case *syntax.CondExpr:
+			n.Cond, n.True, n.False = guardScriptExpr(n.Cond), guardScriptExpr(n.True), guardScriptExpr(n.False)
+		case *syntax.IndexExpr:
+			n.X, n.Y = guardScriptExpr(n.X), guardScriptExpr(n.Y)
+		case *syntax.DictEntry:
+			n.Key, n.Value = guardScriptExpr(n.Key), guardScriptExpr(n.Value)
+		case *syntax.SliceExpr:
+			n.X, n.Lo, n.Hi, n.Step = guardScriptExpr(n.X), guardScriptExpr(n.Lo), guardScriptExpr(n.Hi), guardScriptExpr(n.Step)
+		case *syntax.Comprehension:
+			n.Body = guardScriptExpr(n.Body)
+		case *syntax.ForClause:
+			n.X = guardScriptExpr(n.X)
+		case *syntax.IfClause:
+			n.Cond = guardScriptExpr(n.Cond)
+		case *syntax.UnaryExpr:
+			n.X = guardScriptExpr(n.X)
+		case *syntax.BinaryExpr:
+			n.X, n.Y = guardScriptExpr(n.X), guardScriptExpr(n.Y)
+		case *syntax.DotExpr:
+			n.X = guardScriptExpr(n.X)
+		case *syntax.CallExpr:
+			n.Fn = guardScriptExpr(n.Fn)
+			guardScriptExprs(n.Args)
+		case *syntax.LambdaExpr:
+			n.Body = guardScriptExpr(n.Body)
+		}
+
+		return true
+	}
+
+	syntax.Walk(file, guard)
+	return err
+}
+
+func guardScriptExprs(list []syntax.Expr) {
+	for i, expr := range list {
+		list[i] = guardScriptExpr(expr)
+	}
+}
+
+// guardScriptExpr turns x + y into __mitm_binary__("+", x, y), and s.join(...) into __mitm_method__(s, "join")(...).
+func guardScriptExpr(expr syntax.Expr) syntax.Expr {
+	switch e := expr.(type) {
+	case *syntax.BinaryExpr:
+		if _, ok := scriptGuardedOperators[e.Op.String()]; ok {
+			return newScriptGuardCall(scriptBinaryBuiltin, e.OpPos, newScriptString(e.Op.String(), e.OpPos), e.X, e.Y)
+		}
+	case *syntax.CallExpr:
+		if dot, ok := e.Fn.(*syntax.DotExpr); ok && scriptGuardedMethods[dot.Name.Name] {
+			e.Fn = newScriptGuardCall(scriptMethodBuiltin, dot.Dot, dot.X, newScriptString(dot.Name.Name, dot.NamePos))
+		}
+	}
+
+	return expr
+}
+
+func newScriptGuardCall(name string, pos syntax.Position, args ...syntax.Expr) *syntax.CallExpr {
+	return &syntax.CallExpr{Fn: &syntax.Ident{NamePos: pos, Name: name}, Lparen: pos, Args: args, Rparen: pos}
+}
+
+func newScriptString(value string, pos syntax.Position) *syntax.Literal {
+	return &syntax.Literal{Token: syntax.STRING, TokenPos: pos, Raw: strconv.Quote(value), Value: value}
+}
+
+// cloneScriptTarget copies the target of an augmented assignment, as long as evaluating it has no side effect.
+func cloneScriptTarget(expr syntax.Expr) (syntax.Expr, bool) {
+	switch e := expr.(type) {
+	case *syntax.Ident:
+		return &syntax.Ident{NamePos: e.NamePos, Name: e.Name}, true
+	case *syntax.Literal:
+		clone := *e
+		return &clone, true
+	case *syntax.ParenExpr:
+		x, ok := cloneScriptTarget(e.X)
+		return &syntax.ParenExpr{Lparen: e.Lparen, X: x, Rparen: e.Rparen}, ok
+	case *syntax.DotExpr:
+		x, ok := cloneScriptTarget(e.X)
+		return &syntax.DotExpr{X: x, Dot: e.Dot, NamePos: e.NamePos, Name: &syntax.Ident{NamePos: e.Name.NamePos, Name: e.Name.Name}}, ok
+	case *syntax.IndexExpr:
+		x, okX := cloneScriptTarget(e.X)
+		y, okY := cloneScriptTarget(e.Y)
+		return &syntax.IndexExpr{X: x, Lbrack: e.Lbrack, Y: y, Rbrack: e.Rbrack}, okX && okY
+	case *syntax.UnaryExpr:
+		x, ok := cloneScriptTarget(e.X)
+		return &syntax.UnaryExpr{OpPos: e.OpPos, Op: e.Op, X: x}, ok
+	case *syntax.BinaryExpr:
+		x, okX := cloneScriptTarget(e.X)
+		y, okY := cloneScriptTarget(e.Y)
+		return &syntax.BinaryExpr{X: x, OpPos: e.OpPos, Op: e.Op, Y: y}, okX && okY
+	}
+
+	return nil, false
+}
+
+// scriptMemoryBuiltins returns the builtins called by the guarded expressions, and the size checked
+// versions of the builtins able to build large values.
+func scriptMemoryBuiltins(limit int64) starlark.StringDict {
+	builtins := starlark.StringDict{
+		scriptBinaryBuiltin: starlark.NewBuiltin(scriptBinaryBuiltin, func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+			op, x, y := scriptGuardedOperators[string(args[0].(starlark.String))], args[1], args[2]
+			if err := checkScriptSize(estimateScriptBinary(op, x, y, limit), limit); err != nil {
+				return nil, err
+			}
+
+			return starlark.Binary(op, x, y)
+		}),
+		scriptAugmentedBuiltin: starlark.NewBuiltin(scriptAugmentedBuiltin, func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+			op, x, y := scriptGuardedOperators[string(args[0].(starlark.String))], args[1], args[2]
+
+			size := estimateScriptBinary(op, x, y, limit)
+			if list, ok := x.(*starlark.List); ok && op == syntax.PLUS {
+				// list += iterable extends the list
+				size = estimateScriptSlots(list.Len(), starlark.Len(y))
+			}
+
+			if err := checkScriptSize(size, limit); err != nil {
+				return nil, err
+			}
+
+			return y, nil
+		}),
+		scriptMethodBuiltin: starlark.NewBuiltin(scriptMethodBuiltin, func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+			x, name := args[0], string(args[1].(starlark.String))
+
+			var method starlark.Value
+			if value, ok := x.(starlark.HasAttrs); ok {
+				var err error
+				if method, err = value.Attr(name); err != nil {
+					return nil, err
+				}
+			}
+
+			if method == nil {
+				return nil, fmt.Errorf("%s has no .%s field or method", x.Type(), name)
+			}
+
+			if _, ok := method.(*starlark.Builtin); !ok {
+				return method, nil
+			}
+
+			return guardScriptBuiltin(method.(*starlark.Builtin), limit, func(args starlark.Tuple, kwargs []starlark.Tuple) int64 {
+				return estimateScriptMethod(x, name, args, kwargs, limit)
+			}), nil
+		}),
+	}
+
+	for _, name := range scriptGuardedBuiltins {
+		name := name
+		builtins[name] = guardScriptBuiltin(starlark.Universe[name].(*starlark.Builtin), limit, func(args starlark.Tuple, _ []starlark.Tuple) int64 {
+			return estimateScriptBuiltin(name, args, limit)
+		})
+	}
+
+	members := make(starlark.StringDict, len(json.Module.Members))
+	for name, member := range json.Module.Members {
+		members[name] = member
+	}
+
+	members["encode"] = guardScriptBuiltin(members["encode"].(*starlark.Builtin), limit, func(args starlark.Tuple, _ []starlark.Tuple) int64 {
+		if len(args) == 0 {
+			return 0
+		}
+
+		return estimateScriptText(args[0], limit)
+	})
+
+	members["indent"] = guardScriptBuiltin(members["indent"].(*starlark.Builtin), limit, func(args starlark.Tuple, kwargs []starlark.Tuple) int64 {
+		if len(args) == 0 {
+			return 0
+		}
+
+		// each value is moved to its own line, indented by its depth
+		text, _ := starlark.AsString(args[0])
+		return int64(len(text)) * 4
+	})
+
+	builtins["json"] = &starlarkstruct.Module{Name: json.Module.Name, Members: members}
+	return builtins
+}
+
+// guardScriptBuiltin returns fn, which is only called once the size estimated from its arguments is within the limit.
+func guardScriptBuiltin(fn *starlark.Builtin, limit int64, estimate func(args starlark.Tuple, kwargs []starlark.Tuple) int64) *starlark.Builtin {
+	return starlark.NewBuiltin(fn.Name(), func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := checkScriptSize(estimate(args, kwargs), limit); err != nil {
+			return nil, err
+		}
+
+		return starlark.Call(thread, fn, args, kwargs)
+	})
+}
+
+func checkScriptSize(size int64, limit int64) error {
+	if size > limit {
+		return fmt.Errorf("value of about %d bytes exceeds the scripting max_memory (%d bytes)", size, limit)
+	}
+
+	return nil
+}
+
+// estimateScriptBinary returns the size of the value built by x op y.
+func estimateScriptBinary(op syntax.Token, x, y starlark.Value, limit int64) int64 {
+	switch op {
+	case syntax.PLUS:
+		switch x := x.(type) {
+		case starlark.String:
+			if y, ok := y.(starlark.String); ok {
+				return int64(len(x)) + int64(len(y))
+			}
+		case starlark.Bytes:
+			if y, ok := y.(starlark.Bytes); ok {
+				return int64(len(x)) + int64(len(y))
+			}
+		case *starlark.List, starlark.Tuple:
+			return estimateScriptSlots(starlark.Len(x), starlark.Len(y))
+		}
+	case syntax.STAR:
+		if _, ok := x.(starlark.Int); ok {
+			x, y = y, x
+		}
+
+		n, ok := y.(starlark.Int)
+		if !ok {
+			return 0
+		}
+
+		switch x := x.(type) {
+		case starlark.Int:
+			return int64(x.BigInt().BitLen()+n.BigInt().BitLen()) / 8
+		case starlark.String, starlark.Bytes:
+			return multiplyScriptSize(int64(starlark.Len(x)), n)
+		case *starlark.List, starlark.Tuple:
+			return multiplyScriptSize(int64(starlark.Len(x))*scriptValueSlot, n)
+		}
+	case syntax.PERCENT:
+		if format, ok := x.(starlark.String); ok {
+			return int64(len(format)) + estimateScriptText(y, limit)
+		}
+	case syntax.PIPE:
+		switch x.(type) {
+		case *starlark.Dict, *starlark.Set:
+			// keys and values
+			return 2 * estimateScriptSlots(starlark.Len(x), starlark.Len(y))
+		}
+	}
+
+	return 0
+}
+
+// estimateScriptMethod returns the size of the value built by the guarded method of x.
+func estimateScriptMethod(x starlark.Value, name string, args starlark.Tuple, kwargs []starlark.Tuple, limit int64) int64 {
+	if list, ok := x.(*starlark.List); ok && name == "extend" && len(args) > 0 {
+		return estimateScriptSlots(list.Len(), starlark.Len(args[0]))
+	}
+
+	text, ok := x.(starlark.String)
+	if !ok {
+		return 0
+	}
+
+	s := string(text)
+	switch name {
+	case "join":
+		if len(args) == 0 {
+			return 0
+		}
+
+		iterable, ok := args[0].(starlark.Iterable)
+		if !ok {
+			return 0
+		}
+
+		iter := iterable.Iterate()
+		defer iter.Done()
+
+		var size int64
+		var item starlark.Value
+		for iter.Next(&item) && size <= limit {
+			if value, ok := item.(starlark.String); ok {
+				size += int64(len(value))
+			}
+
+			size += int64(len(s))
+		}
+
+		return size
+	case "replace":
+		if len(args) < 2 {
+			return 0
+		}
+
+		old, _ := starlark.AsString(args[0])
+		replacement, _ := starlark.AsString(args[1])
+
+		count := int64(strings.Count(s, old))
+		if len(args) > 2 {
+			if n, err := starlark.AsInt32(args[2]); err == nil && n >= 0 && int64(n) < count {
+				count = int64(n)
+			}
+		}
+
+		return int64(len(s)) + count*int64(len(replacement))
+	case "format":
+		var largest int64
+		for _, arg := range args {
+			largest = max(largest, estimateScriptText(arg, limit))
+		}
+
+		for _, kwarg := range kwargs {
+			largest = max(largest, estimateScriptText(kwarg[1], limit))
+		}
+
+		// each field may repeat the largest argument
+		return int64(len(s)) + int64(strings.Count(s, "{"))*largest
+	case "split", "rsplit", "splitlines":
+		parts := int64(len(s))/2 + 1
+		if name == "splitlines" {
+			parts = int64(strings.Count(s, "\n")) + 1
+		} else if len(args) > 0 {
+			if sep, ok := args[0].(starlark.String); ok && sep != "" {
+				parts = int64(strings.Count(s, string(sep))) + 1
+			}
+		}
+
+		// each part is a string referenced by the list
+		return int64(len(s)) + parts*2*scriptValueSlot
+	}
+
+	return 0
+}
+
+// estimateScriptBuiltin returns the size of the value built by the guarded builtin.
+func estimateScriptBuiltin(name string, args starlark.Tuple, limit int64) int64 {
+	if len(args) == 0 {
+		return 0
+	}
+
+	switch name {
+	case "str":
+		if _, ok := args[0].(starlark.String); ok {
+			return 0
+		}
+
+		return estimateScriptText(args[0], limit)
+	case "repr":
+		return estimateScriptText(args[0], limit)
+	case "zip":
+		shortest := -1
+		for _, arg := range args {
+			if n := starlark.Len(arg); n >= 0 && (shortest < 0 || n < shortest) {
+				shortest = n
+			}
+		}
+
+		return estimateScriptSlots(shortest*(len(args)+1), 0)
+	case "enumerate":
+		// a list of (index, value) tuples
+		return estimateScriptSlots(starlark.Len(args[0])*3, 0)
+	}
+
+	return estimateScriptSlots(starlark.Len(args[0]), 0)
+}
+
+// estimateScriptText returns the approximate length of the text of v (str, repr, json.encode), without
+// following the values already being printed, as Starlark prints them as "[...]".
+func estimateScriptText(v starlark.Value, limit int64) int64 {
+	var size int64
+	var path []starlark.Value
+
+	var visit func(v starlark.Value)
+	visit = func(v starlark.Value) {
+		if size > limit {
+			return
+		}
+
+		switch v := v.(type) {
+		case starlark.String:
+			size += int64(len(v)) + 2
+			return
+		case starlark.Bytes:
+			size += int64(len(v)) + 3
+			return
+		case starlark.Int:
+			size += int64(v.BigInt().BitLen())/3 + 2
+			return
+		case *starlark.List, starlark.Tuple, *starlark.Dict, *starlark.Set:
+		default:
+			size += 32
+			return
+		}
+
+		for _, parent := range path {
+			if parent == v {
+				size += 5
+				return
+			}
+		}
+
+		path = append(path, v)
+		defer func() { path = path[:len(path)-1] }()
+
+		size += 2
+		if dict, ok := v.(*starlark.Dict); ok {
+			for _, item := range dict.Items() {
+				size += 4
+				visit(item[0])
+				visit(item[1])
+			}
+
+			return
+		}
+
+		iter := v.(starlark.Iterable).Iterate()
+		defer iter.Done()
+
+		var item starlark.Value
+		for iter.Next(&item) && size <= limit {
+			size += 2
+			visit(item)
+		}
+	}
+
+	visit(v)
+	return size
+}
+
+// estimateScriptSlots returns the size of a list, tuple, dict or set holding x + y values; unknown lengths (-1) count as empty.
+func estimateScriptSlots(x int, y int) int64 {
+	return int64(max(x, 0)+max(y, 0)) * scriptValueSlot
+}
+
+func multiplyScriptSize(size int64, n starlark.Int) int64 {
+	count, ok := n.Int64()
+	if !ok {
+		return math.MaxInt64
+	}
+
+	if size == 0 || count <= 0 {
+		return 0
+	}
+
+	if count > math.MaxInt64/size {
+		return math.MaxInt64
+	}
+
+	return size * count
+}
@@ -19,9 +19,14 @@ import "github.com/elazarl/goproxy"
 type dataKey string
 
 const (
-	IDKey    dataKey = "uuid"
-	ProxyKey dataKey = "proxified"
-	CacheKey dataKey = "cache"
+	IDKey       dataKey = "uuid"
+	ProxyKey    dataKey = "proxified"
+	CacheKey    dataKey = "cache"
+	FlightKey   dataKey = "flight"
+	ClientKey   dataKey = "client"
+	CommandsKey dataKey = "commands"
+	HitsKey     dataKey = "hits"
+	RewriteKey  dataKey = "rewrite"
 )
 
 type CustomProxyCtx struct {
@@ -0,0 +1,170 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	helpers "infinite-mitm/internal/application/services/mitm/helpers"
+	"infinite-mitm/pkg/mitm"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/elazarl/goproxy"
+)
+
+// upstreamOverride forwards every request of a service, with the same method, headers and body, to another backend (e.g., a local mock).
+type upstreamOverride struct {
+	hosts       *helpers.InterceptHosts
+	target      *url.URL
+	stripPrefix string
+	transport   *http.Transport
+}
+
+type upstreamOverrides []*upstreamOverride
+
+// newUpstreamOverrides creates the "upstream_override" entries of the mitm.yaml, keyed by hostname ("settings.svc.halowaypoint.com")
+// or wildcard ("*.svc.halowaypoint.com"); exact hostnames take precedence over wildcards.
+func newUpstreamOverrides(options map[string]mitm.YAMLUpstreamOverride, base *http.Transport, dialer *resolvingDialer) (upstreamOverrides, error) {
+	patterns := make([]string, 0, len(options))
+	for pattern := range options {
+		patterns = append(patterns, pattern)
+	}
+
+	sort.SliceStable(patterns, func(i, j int) bool {
+		return !strings.Contains(patterns[i], "*") && strings.Contains(patterns[j], "*")
+	})
+
+	var overrides upstreamOverrides
+	for _, pattern := range patterns {
+		override, err := newUpstreamOverride(pattern, options[pattern], base, dialer)
+		if err != nil {
+			return nil, err
+		}
+
+		overrides = append(overrides, override)
+	}
+
+	return overrides, nil
+}
+
+func newUpstreamOverride(pattern string, options mitm.YAMLUpstreamOverride, base *http.Transport, dialer *resolvingDialer) (*upstreamOverride, error) {
+	target, err := url.Parse(options.Target)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("invalid upstream_override target for %s: %s", pattern, options.Target)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: options.Insecure}
+	if options.CA != "" {
+		data, err := os.ReadFile(options.CA)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream_override ca for %s: %s", pattern, err.Error())
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("invalid upstream_override ca for %s: no PEM certificate found", pattern)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	// keeps the timeouts and pool options of the proxy transport, but dials the target directly (e.g., a local mock):
+	// neither the upstream proxy nor the HTTP(S)_PROXY environment variables apply
+	transport := base.Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	transport.Dial = nil
+	transport.TLSClientConfig = tlsConfig
+
+	return &upstreamOverride{
+		hosts:       helpers.NewInterceptHosts([]string{pattern}),
+		target:      target,
+		stripPrefix: strings.TrimSuffix(options.StripPrefix, "/"),
+		transport:   transport,
+	}, nil
+}
+
+func (o upstreamOverrides) Match(hostname string) *upstreamOverride {
+	for _, override := range o {
+		if override.hosts.Matches(hostname) {
+			return override
+		}
+	}
+
+	return nil
+}
+
+func (o upstreamOverrides) HasHost(hostname string) bool {
+	return o.Match(hostname) != nil
+}
+
+// RoundTrip sends req to the target backend; the response keeps the original request so the rules and the network table
+// still see the real service URL.
+func (o *upstreamOverride) RoundTrip(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Response, error) {
+	forwarded := req.Clone(req.Context())
+	forwarded.URL = o.rewriteURL(req.URL)
+	forwarded.Host = ""
+	forwarded.RequestURI = ""
+	forwarded.Header.Set("X-Forwarded-Host", req.Host)
+	forwarded.Header.Set("X-Forwarded-Proto", req.URL.Scheme)
+
+	resp, err := o.transport.RoundTrip(forwarded)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Request = req
+	return resp, nil
+}
+
+// prefetchTransport sends the SmartCache prefetch requests like the proxy does: to the upstream override of their host, if any,
+// or through the proxy transport.
+type prefetchTransport struct {
+	transport *http.Transport
+	overrides upstreamOverrides
+}
+
+func (t *prefetchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if override := t.overrides.Match(req.URL.Hostname()); override != nil {
+		return override.RoundTrip(req, nil)
+	}
+
+	return t.transport.RoundTrip(req)
+}
+
+// rewriteURL strips the configured prefix from the path, then appends it to the target one
+// (e.g., https://settings.svc.halowaypoint.com/hipc/... → http://127.0.0.1:8080/mock/...).
+func (o *upstreamOverride) rewriteURL(source *url.URL) *url.URL {
+	path := source.Path
+	if o.stripPrefix != "" && (path == o.stripPrefix || strings.HasPrefix(path, o.stripPrefix+"/")) {
+		path = strings.TrimPrefix(path, o.stripPrefix)
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	rewritten := *o.target
+	rewritten.Path = strings.TrimSuffix(o.target.Path, "/") + path
+	rewritten.RawPath = ""
+	rewritten.RawQuery = source.RawQuery
+	rewritten.Fragment = ""
+	return &rewritten
+}
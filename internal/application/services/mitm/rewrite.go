@@ -0,0 +1,125 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"fmt"
+	"infinite-mitm/pkg/domains"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/elazarl/goproxy"
+)
+
+// requestRewrite sends the request to its rewritten URL, through the upstream override of the new host if any.
+type requestRewrite struct {
+	target   *url.URL
+	override *upstreamOverride
+}
+
+var redirectStatusCodes = []int{http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect}
+
+func hasRewrite(rewrite domains.YAMLDomainRewrite) bool {
+	return rewrite.Host != "" || rewrite.Path != "" || rewrite.Query != "" || rewrite.Redirect != 0
+}
+
+func validateRewrite(rewrite domains.YAMLDomainRewrite) error {
+	if rewrite.Redirect == 0 {
+		return nil
+	}
+
+	// the client would be redirected to the URL it requested, forever
+	if rewrite.Host == "" && rewrite.Path == "" && rewrite.Query == "" {
+		return fmt.Errorf("redirect requires a host, path or query")
+	}
+
+	for _, statusCode := range redirectStatusCodes {
+		if rewrite.Redirect == statusCode {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid redirect code: %d (expected 301, 302 or 307)", rewrite.Redirect)
+}
+
+// rewriteURL builds the new URL of the request; the $1 matches, route parameters and ${name} session variables
+// of the host, path and query are replaced.
+func rewriteURL(rewrite domains.YAMLDomainRewrite, matches []string, source *url.URL) (*url.URL, error) {
+	rewritten := *source
+	rewritten.Fragment = ""
+
+	if rewrite.Host != "" {
+		host := replaceVariables(rewrite.Host, matches)
+		if host == "" || strings.ContainsAny(host, "/?#@ ") {
+			return nil, fmt.Errorf("invalid host: %q", host)
+		}
+
+		rewritten.Host = host
+	}
+
+	if rewrite.Path != "" {
+		path := replaceVariables(rewrite.Path, matches)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+
+		rewritten.Path = path
+		rewritten.RawPath = ""
+	}
+
+	if rewrite.Query != "" {
+		query := strings.TrimPrefix(replaceVariables(rewrite.Query, matches), "?")
+		if _, err := url.ParseQuery(query); err != nil {
+			return nil, fmt.Errorf("invalid query: %q", query)
+		}
+
+		rewritten.RawQuery = query
+	}
+
+	return &rewritten, nil
+}
+
+// redirectRequest answers the request with a redirection to location, without contacting the service.
+func redirectRequest(req *http.Request, location *url.URL, statusCode int) *http.Response {
+	resp := goproxy.NewResponse(req, goproxy.ContentTypeText, statusCode, "")
+	resp.Header.Set("Location", location.String())
+	return resp
+}
+
+// RoundTrip sends a copy of req to the rewritten URL; the response keeps the original request so the rules and the network table
+// still see the URL requested by the client.
+func (r *requestRewrite) RoundTrip(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Response, error) {
+	rewritten := req.Clone(req.Context())
+	rewritten.URL = r.target
+	rewritten.Host = r.target.Host
+	rewritten.RequestURI = ""
+
+	var resp *http.Response
+	var err error
+
+	if r.override != nil {
+		resp, err = r.override.RoundTrip(rewritten, ctx)
+	} else {
+		resp, err = ctx.Proxy.Tr.RoundTrip(rewritten)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Request = req
+	return resp, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"infinite-mitm/pkg/domains"
+	"net/url"
+	"testing"
+)
+
+func TestRewriteURL(t *testing.T) {
+	source, _ := url.Parse("https://settings.svc.halowaypoint.com/hipc/spartan?flight=1#top")
+	setSessionVariable("test-region", "eu")
+
+	tests := []struct {
+		name    string
+		rewrite domains.YAMLDomainRewrite
+		matches []string
+		want    string
+		wantErr bool
+	}{
+		{"nothing to rewrite", domains.YAMLDomainRewrite{}, nil, "https://settings.svc.halowaypoint.com/hipc/spartan?flight=1", false},
+		{"host", domains.YAMLDomainRewrite{Host: "127.0.0.1:8080"}, nil, "https://127.0.0.1:8080/hipc/spartan?flight=1", false},
+		{"path with matches", domains.YAMLDomainRewrite{Path: "/mock/$1"}, []string{"spartan"}, "https://settings.svc.halowaypoint.com/mock/spartan?flight=1", false},
+		{"path without leading slash", domains.YAMLDomainRewrite{Path: "mock"}, nil, "https://settings.svc.halowaypoint.com/mock?flight=1", false},
+		{"query with session variables", domains.YAMLDomainRewrite{Query: "?region=${test-region}"}, nil, "https://settings.svc.halowaypoint.com/hipc/spartan?region=eu", false},
+		{"empty host", domains.YAMLDomainRewrite{Host: "$1"}, nil, "", true},
+		{"host with a path", domains.YAMLDomainRewrite{Host: "example.com/path"}, nil, "", true},
+		{"invalid query", domains.YAMLDomainRewrite{Query: "a=%zz"}, nil, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := rewriteURL(test.rewrite, test.matches, source)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("rewriteURL() error = %v, wantErr %t", err, test.wantErr)
+			}
+
+			if !test.wantErr && got.String() != test.want {
+				t.Errorf("rewriteURL() = %s, want %s", got, test.want)
+			}
+		})
+	}
+
+	if source.String() != "https://settings.svc.halowaypoint.com/hipc/spartan?flight=1#top" {
+		t.Errorf("rewriteURL() altered the source URL: %s", source)
+	}
+}
+
+func TestValidateRewrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		rewrite domains.YAMLDomainRewrite
+		wantErr bool
+	}{
+		{"no redirect", domains.YAMLDomainRewrite{Host: "127.0.0.1:8080"}, false},
+		{"redirect to another host", domains.YAMLDomainRewrite{Host: "example.com", Redirect: 302}, false},
+		{"redirect to another path", domains.YAMLDomainRewrite{Path: "/v2/$1", Redirect: 307}, false},
+		{"redirect to another query", domains.YAMLDomainRewrite{Query: "flight=2", Redirect: 301}, false},
+		{"redirect to the requested URL", domains.YAMLDomainRewrite{Redirect: 302}, true},
+		{"unsupported redirect code", domains.YAMLDomainRewrite{Path: "/v2", Redirect: 303}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validateRewrite(test.rewrite); (err != nil) != test.wantErr {
+				t.Errorf("validateRewrite() error = %v, wantErr %t", err, test.wantErr)
+			}
+		})
+	}
+}
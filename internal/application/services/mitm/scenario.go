@@ -0,0 +1,218 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"fmt"
+	eventsService "infinite-mitm/internal/application/services/events"
+	"infinite-mitm/pkg/domains"
+	"infinite-mitm/pkg/utilities"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gookit/event"
+)
+
+// scenario is the running state machine of a "scenarios" entry of the mitm.yaml.
+type scenario struct {
+	name      string
+	initial   string
+	states    []string
+	variables map[string]string
+
+	mutex sync.Mutex
+	state string
+	vars  map[string]string
+}
+
+// scenarioSnapshot is exposed to the body templates (e.g., {{.Scenarios.matchmaking.State}}, {{.Scenarios.matchmaking.Vars.lobby}}).
+type scenarioSnapshot struct {
+	State string
+	Vars  map[string]string
+}
+
+// scenarioRule is the "scenario" block of a rule.
+type scenarioRule struct {
+	scenario *scenario
+	when     string
+	then     string
+	set      map[string]string
+}
+
+// scenarios restart from their initial state when the mitm.yaml is saved
+var (
+	scenarios      = map[string]*scenario{}
+	scenariosMutex sync.RWMutex
+)
+
+func setScenarios(entries map[string]domains.YAMLScenario) error {
+	list := make(map[string]*scenario, len(entries))
+
+	for name, entry := range entries {
+		if entry.Initial == "" {
+			return fmt.Errorf("invalid scenario %s; missing initial state", name)
+		}
+
+		if len(entry.States) != 0 && !utilities.Contains(entry.States, entry.Initial) {
+			return fmt.Errorf("invalid scenario %s; unknown initial state: %s", name, entry.Initial)
+		}
+
+		item := &scenario{name: name, initial: entry.Initial, states: entry.States, variables: entry.Variables}
+		item.reset()
+		list[name] = item
+	}
+
+	scenariosMutex.Lock()
+	defer scenariosMutex.Unlock()
+
+	scenarios = list
+	return nil
+}
+
+func (s *scenario) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.state = s.initial
+	s.vars = make(map[string]string, len(s.variables))
+	for k, v := range s.variables {
+		s.vars[k] = v
+	}
+}
+
+func (s *scenario) snapshot() scenarioSnapshot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	vars := make(map[string]string, len(s.vars))
+	for k, v := range s.vars {
+		vars[k] = v
+	}
+
+	return scenarioSnapshot{State: s.state, Vars: vars}
+}
+
+// newScenarioRule validates the "scenario" block of a rule; nil is returned when the block is empty.
+func newScenarioRule(node domains.YAMLDomainScenario) (*scenarioRule, error) {
+	if node.Name == "" {
+		if node.When != "" || node.Then != "" || len(node.Set) != 0 {
+			return nil, fmt.Errorf("missing scenario name")
+		}
+
+		return nil, nil
+	}
+
+	scenariosMutex.RLock()
+	item, ok := scenarios[node.Name]
+	scenariosMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown scenario: %s (declare it in scenarios)", node.Name)
+	}
+
+	for _, state := range []string{node.When, node.Then} {
+		if state != "" && len(item.states) != 0 && !utilities.Contains(item.states, state) {
+			return nil, fmt.Errorf("unknown state of %s: %s (expected %s)", node.Name, state, strings.Join(item.states, ", "))
+		}
+	}
+
+	return &scenarioRule{scenario: item, when: node.When, then: node.Then, set: node.Set}, nil
+}
+
+// transitions reports whether the rule changes the scenario when it fires.
+func (r *scenarioRule) transitions() bool {
+	return r != nil && (r.then != "" || len(r.set) != 0)
+}
+
+// allows reports whether the scenario is in the state required by the rule.
+func (r *scenarioRule) allows() bool {
+	if r == nil || r.when == "" {
+		return true
+	}
+
+	r.scenario.mutex.Lock()
+	defer r.scenario.mutex.Unlock()
+
+	return r.scenario.state == r.when
+}
+
+// fire moves the scenario to the next state and sets its variables ($1 refers to the first match of the rule);
+// false is returned when another request has moved the scenario out of the required state in the meantime.
+func (r *scenarioRule) fire(matches []string) bool {
+	if r == nil {
+		return true
+	}
+
+	item := r.scenario
+	item.mutex.Lock()
+	defer item.mutex.Unlock()
+
+	if r.when != "" && item.state != r.when {
+		return false
+	}
+
+	if r.then != "" {
+		item.state = r.then
+	}
+
+	for key, value := range r.set {
+		item.vars[key] = replaceVariables(value, matches)
+	}
+
+	return true
+}
+
+func getScenarioSnapshots() map[string]scenarioSnapshot {
+	scenariosMutex.RLock()
+	defer scenariosMutex.RUnlock()
+
+	snapshots := make(map[string]scenarioSnapshot, len(scenarios))
+	for name, item := range scenarios {
+		snapshots[name] = item.snapshot()
+	}
+
+	return snapshots
+}
+
+// getScenariosSummary returns the current state of each scenario, displayed in the status bar (e.g., "matchmaking: lobby").
+func getScenariosSummary() string {
+	snapshots := getScenarioSnapshots()
+
+	names := make([]string, 0, len(snapshots))
+	for name := range snapshots {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	states := make([]string, 0, len(names))
+	for _, name := range names {
+		states = append(states, fmt.Sprintf("%s: %s", name, snapshots[name].State))
+	}
+
+	return strings.Join(states, " · ")
+}
+
+// ResetScenarios moves every scenario back to its initial state, with its initial variables.
+func ResetScenarios() {
+	scenariosMutex.RLock()
+	for _, item := range scenarios {
+		item.reset()
+	}
+	scenariosMutex.RUnlock()
+
+	event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": "scenarios: reset"})
+}
@@ -0,0 +1,619 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"bytes"
+	"fmt"
+	eventsService "infinite-mitm/internal/application/services/events"
+	"infinite-mitm/pkg/mitm"
+	"infinite-mitm/pkg/pattern"
+	"infinite-mitm/pkg/utilities"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gookit/event"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+const (
+	defaultScriptTimeout   = 2 * time.Second
+	defaultScriptMaxSteps  = 10_000_000
+	defaultScriptMaxMemory = 64 * 1024 * 1024
+	defaultScriptMaxBody   = 10 * 1024 * 1024
+)
+
+// scriptOptions are the "scripting" options of the mitm.yaml, shared by the scripts of every rule.
+type scriptOptions struct {
+	timeout   time.Duration
+	maxSteps  uint64
+	maxMemory int64
+	maxBody   int64
+	allowRead []string
+}
+
+// scriptHooks lists the hooks defined by a rule script.
+type scriptHooks struct {
+	request  bool
+	response bool
+}
+
+type compiledScript struct {
+	modTime time.Time
+	size    int64
+	program *starlark.Program
+}
+
+// scriptRun is a fresh instance of a script: its top-level code runs again for every hook call, so no state is shared between calls.
+type scriptRun struct {
+	thread  *starlark.Thread
+	globals starlark.StringDict
+	options scriptOptions
+	stop    func()
+}
+
+var (
+	scripting = scriptOptions{
+		timeout:   defaultScriptTimeout,
+		maxSteps:  defaultScriptMaxSteps,
+		maxMemory: defaultScriptMaxMemory,
+		maxBody:   defaultScriptMaxBody,
+	}
+	scriptingMutex sync.RWMutex
+)
+
+var (
+	compiledScripts      = map[string]*compiledScript{}
+	compiledScriptsMutex sync.Mutex
+)
+
+var scriptFileOptions = &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true}
+
+// setScriptingOptions replaces the "scripting" options of the mitm.yaml and drops the compiled scripts.
+func setScriptingOptions(options mitm.YAMLScriptingOptions) {
+	timeout := parseTimeout(options.Timeout, defaultScriptTimeout)
+	if timeout == 0 {
+		timeout = defaultScriptTimeout
+	}
+
+	maxSteps := options.MaxSteps
+	if maxSteps == 0 {
+		maxSteps = defaultScriptMaxSteps
+	}
+
+	var allowRead []string
+	for _, dir := range options.AllowRead {
+		if abs, err := resolveScriptPath(dir); err == nil {
+			allowRead = append(allowRead, abs)
+		}
+	}
+
+	scriptingMutex.Lock()
+	scripting = scriptOptions{
+		timeout:   timeout,
+		maxSteps:  maxSteps,
+		maxMemory: parseScriptSize(options.MaxMemory, defaultScriptMaxMemory),
+		maxBody:   parseScriptSize(options.MaxBody, defaultScriptMaxBody),
+		allowRead: allowRead,
+	}
+	scriptingMutex.Unlock()
+
+	compiledScriptsMutex.Lock()
+	compiledScripts = map[string]*compiledScript{}
+	compiledScriptsMutex.Unlock()
+}
+
+func getScriptingOptions() scriptOptions {
+	scriptingMutex.RLock()
+	defer scriptingMutex.RUnlock()
+
+	return scripting
+}
+
+func parseScriptSize(value string, fallback int64) int64 {
+	if value == "" {
+		return fallback
+	}
+
+	size, err := utilities.ParseByteSize(value)
+	if err != nil || size <= 0 {
+		return fallback
+	}
+
+	return size
+}
+
+// getScriptHooks returns the hooks (on_request, on_response) defined by the script of a rule.
+func getScriptHooks(source string) (scriptHooks, error) {
+	run, err := startScript(source)
+	if err != nil {
+		return scriptHooks{}, err
+	}
+
+	defer run.stop()
+	_, onRequest := run.globals["on_request"].(starlark.Callable)
+	_, onResponse := run.globals["on_response"].(starlark.Callable)
+
+	if !onRequest && !onResponse {
+		return scriptHooks{}, fmt.Errorf("no on_request or on_response function defined")
+	}
+
+	return scriptHooks{request: onRequest, response: onResponse}, nil
+}
+
+// runRequestScript calls the on_request hook of the script; it may alter req, or return a synthetic response
+// when the hook returns a dict with a "status".
+func runRequestScript(source string, matches []string, req *http.Request) (*http.Response, error) {
+	run, err := startScript(source)
+	if err != nil {
+		return nil, err
+	}
+
+	defer run.stop()
+	hook, ok := run.globals["on_request"].(starlark.Callable)
+	if !ok {
+		return nil, nil
+	}
+
+	body, original, err := readTrafficBody(req.Body, req.Header, run.options.maxBody)
+	if err != nil {
+		// the request is forwarded untouched
+		req.Body = original
+		return nil, scriptBodyError("request", err, run.options)
+	}
+
+	setRequestBody(req, body)
+
+	value, headers := newScriptRequest(req, body, matches)
+	result, err := starlark.Call(run.thread, hook, starlark.Tuple{value}, nil)
+	if err != nil {
+		return nil, scriptError(err)
+	}
+
+	dict, err := scriptResult("on_request", result, value, run.options)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, found, _ := dict.Get(starlark.String("status")); found {
+		resp := &http.Response{
+			Request:    req,
+			StatusCode: http.StatusOK,
+			Status:     http.StatusText(http.StatusOK),
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{},
+			Body:       http.NoBody,
+		}
+
+		if err := applyScriptResponse(dict, map[string]string{}, resp); err != nil {
+			return nil, err
+		}
+
+		return resp, nil
+	}
+
+	return nil, applyScriptRequest(dict, headers, req)
+}
+
+// runResponseScript calls the on_response hook of the script, which may alter resp.
+func runResponseScript(source string, matches []string, resp *http.Response) error {
+	run, err := startScript(source)
+	if err != nil {
+		return err
+	}
+
+	defer run.stop()
+	hook, ok := run.globals["on_response"].(starlark.Callable)
+	if !ok {
+		return nil
+	}
+
+	body, original, err := readTrafficBody(resp.Body, resp.Header, run.options.maxBody)
+	if err != nil {
+		// the response is forwarded untouched
+		resp.Body = original
+		return scriptBodyError("response", err, run.options)
+	}
+
+	setResponseBody(resp, body)
+
+	// the request body was already sent; only its metadata is exposed
+	reqValue, _ := newScriptRequest(resp.Request, nil, matches)
+	reqValue.Freeze()
+
+	headers := newScriptHeaders(resp.Header)
+	value := starlark.NewDict(3)
+	value.SetKey(starlark.String("status"), starlark.MakeInt(resp.StatusCode))
+	value.SetKey(starlark.String("headers"), headers)
+	value.SetKey(starlark.String("body"), starlark.String(body))
+
+	result, err := starlark.Call(run.thread, hook, starlark.Tuple{reqValue, value}, nil)
+	if err != nil {
+		return scriptError(err)
+	}
+
+	dict, err := scriptResult("on_response", result, value, run.options)
+	if err != nil {
+		return err
+	}
+
+	return applyScriptResponse(dict, headersToMap(resp.Header), resp)
+}
+
+// startScript instantiates the script, guarded by the scripting timeout and max_steps options.
+func startScript(source string) (*scriptRun, error) {
+	program, err := loadScript(source)
+	if err != nil {
+		return nil, err
+	}
+
+	options := getScriptingOptions()
+	thread := &starlark.Thread{
+		Name: source,
+		Print: func(_ *starlark.Thread, msg string) {
+			event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": fmt.Sprintf("[%s] %s", filepath.Base(source), msg)})
+		},
+		Load: func(_ *starlark.Thread, module string) (starlark.StringDict, error) {
+			return nil, fmt.Errorf("load(%q) is not allowed", module)
+		},
+	}
+
+	thread.SetMaxExecutionSteps(options.maxSteps)
+	run := &scriptRun{thread: thread, options: options, stop: guardScript(thread, options)}
+
+	run.globals, err = program.Init(thread, scriptPredeclared(options))
+	if err != nil {
+		run.stop()
+		return nil, scriptError(err)
+	}
+
+	return run, nil
+}
+
+// loadScript compiles the script file once, until it is modified.
+func loadScript(source string) (*starlark.Program, error) {
+	path, err := resolveScriptPath(source)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	compiledScriptsMutex.Lock()
+	defer compiledScriptsMutex.Unlock()
+
+	if compiled, ok := compiledScripts[path]; ok && compiled.modTime.Equal(info.ModTime()) && compiled.size == info.Size() {
+		return compiled.program, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	program, err := compileScript(path, data, scriptPredeclared(scriptOptions{}).Has)
+	if err != nil {
+		return nil, err
+	}
+
+	compiledScripts[path] = &compiledScript{modTime: info.ModTime(), size: info.Size(), program: program}
+	return program, nil
+}
+
+func resolveScriptPath(source string) (string, error) {
+	return filepath.Abs(filepath.Clean(pattern.ReplaceParameters(source)))
+}
+
+// guardScript cancels the thread once the timeout is exceeded.
+func guardScript(thread *starlark.Thread, options scriptOptions) func() {
+	done := make(chan struct{})
+	go func() {
+		timeout := time.NewTimer(options.timeout)
+		defer timeout.Stop()
+
+		select {
+		case <-done:
+		case <-timeout.C:
+			thread.Cancel(fmt.Sprintf("timeout of %s exceeded", options.timeout))
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+func scriptBodyError(kind string, err error, options scriptOptions) error {
+	if err == errTrafficBodyTooLarge {
+		return fmt.Errorf("%s body exceeds the scripting max_body (%d bytes)", kind, options.maxBody)
+	}
+
+	return err
+}
+
+// scriptPredeclared returns the globals available to the scripts: the json module and read_file, limited to the allow_read directories,
+// as well as the builtins checking the size of the values built against max_memory.
+func scriptPredeclared(options scriptOptions) starlark.StringDict {
+	predeclared := starlark.StringDict{
+		"read_file": starlark.NewBuiltin("read_file", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var source string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &source); err != nil {
+				return nil, err
+			}
+
+			path, err := resolveScriptPath(source)
+			if err != nil {
+				return nil, err
+			}
+
+			if !isScriptReadAllowed(path, options.allowRead) {
+				return nil, fmt.Errorf("%s: access to %s is not granted (see scripting.allow_read)", b.Name(), path)
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+
+			if info.Size() > options.maxBody {
+				return nil, fmt.Errorf("%s: %s exceeds the scripting max_body (%d bytes)", b.Name(), path, options.maxBody)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+
+			return starlark.String(data), nil
+		}),
+	}
+
+	for name, value := range scriptMemoryBuiltins(options.maxMemory) {
+		predeclared[name] = value
+	}
+
+	return predeclared
+}
+
+// isScriptReadAllowed reports whether path, once its symbolic links are resolved, is within one of the allowed directories.
+func isScriptReadAllowed(path string, allowRead []string) bool {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+
+	for _, dir := range allowRead {
+		if resolvedDir, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = resolvedDir
+		}
+
+		rel, err := filepath.Rel(dir, resolved)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newScriptRequest returns the request exposed to the hooks: method, url, headers (lowercase names), body and captures ($1, $2...),
+// as well as a copy of the headers to detect their changes.
+func newScriptRequest(req *http.Request, body []byte, matches []string) (*starlark.Dict, map[string]string) {
+	captures := make([]starlark.Value, 0, len(matches))
+	for _, match := range matches {
+		captures = append(captures, starlark.String(match))
+	}
+
+	value := starlark.NewDict(5)
+	value.SetKey(starlark.String("method"), starlark.String(req.Method))
+	value.SetKey(starlark.String("url"), starlark.String(req.URL.String()))
+	value.SetKey(starlark.String("headers"), newScriptHeaders(req.Header))
+	value.SetKey(starlark.String("body"), starlark.String(body))
+	value.SetKey(starlark.String("captures"), starlark.NewList(captures))
+
+	return value, headersToMap(req.Header)
+}
+
+func newScriptHeaders(header http.Header) *starlark.Dict {
+	headers := starlark.NewDict(len(header))
+	for key, value := range headersToMap(header) {
+		headers.SetKey(starlark.String(key), starlark.String(value))
+	}
+
+	return headers
+}
+
+func headersToMap(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for key, values := range header {
+		headers[strings.ToLower(key)] = strings.Join(values, ", ")
+	}
+
+	return headers
+}
+
+// scriptResult returns the dict returned by a hook, or the one it received when it returned None.
+// scriptResult returns the dict returned by the hook, whose body must not exceed max_body.
+func scriptResult(hook string, result starlark.Value, fallback *starlark.Dict, options scriptOptions) (*starlark.Dict, error) {
+	// the fallback is the dict passed to the hook, which may have altered it
+	dict := fallback
+	if result != starlark.None {
+		var ok bool
+		if dict, ok = result.(*starlark.Dict); !ok {
+			return nil, fmt.Errorf("%s must return None or a dict, got %s", hook, result.Type())
+		}
+	}
+
+	if body, found, _ := scriptString(dict, "body"); found && int64(len(body)) > options.maxBody {
+		return nil, fmt.Errorf("body returned by %s exceeds the scripting max_body (%d bytes)", hook, options.maxBody)
+	}
+
+	return dict, nil
+}
+
+func applyScriptRequest(dict *starlark.Dict, original map[string]string, req *http.Request) error {
+	if method, found, err := scriptString(dict, "method"); err != nil {
+		return err
+	} else if found && method != "" {
+		req.Method = strings.ToUpper(method)
+	}
+
+	if rawURL, found, err := scriptString(dict, "url"); err != nil {
+		return err
+	} else if found && rawURL != req.URL.String() {
+		target, err := url.Parse(rawURL)
+		if err != nil || target.Host == "" {
+			return fmt.Errorf("invalid url returned by on_request: %s", rawURL)
+		}
+
+		req.URL = target
+		req.Host = target.Host
+	}
+
+	if err := applyScriptHeaders(dict, original, req.Header); err != nil {
+		return err
+	}
+
+	if body, found, err := scriptString(dict, "body"); err != nil {
+		return err
+	} else if found {
+		setRequestBody(req, []byte(body))
+	}
+
+	return nil
+}
+
+func applyScriptResponse(dict *starlark.Dict, original map[string]string, resp *http.Response) error {
+	if value, found, _ := dict.Get(starlark.String("status")); found {
+		status, err := starlark.AsInt32(value)
+		if err != nil || status < 100 || status > 999 {
+			return fmt.Errorf("invalid status returned by the script: %s", value.String())
+		}
+
+		resp.StatusCode = status
+		resp.Status = http.StatusText(status)
+	}
+
+	if err := applyScriptHeaders(dict, original, resp.Header); err != nil {
+		return err
+	}
+
+	if body, found, err := scriptString(dict, "body"); err != nil {
+		return err
+	} else if found {
+		setResponseBody(resp, []byte(body))
+	}
+
+	return nil
+}
+
+// applyScriptHeaders only applies the headers added, changed or removed by the script, keeping the other ones untouched (e.g., multiple Set-Cookie).
+func applyScriptHeaders(dict *starlark.Dict, original map[string]string, header http.Header) error {
+	value, found, _ := dict.Get(starlark.String("headers"))
+	if !found {
+		return nil
+	}
+
+	headers, ok := value.(*starlark.Dict)
+	if !ok {
+		return fmt.Errorf("headers must be a dict, got %s", value.Type())
+	}
+
+	updated := make(map[string]string, headers.Len())
+	for _, item := range headers.Items() {
+		key, ok := starlark.AsString(item[0])
+		if !ok {
+			return fmt.Errorf("header names must be strings, got %s", item[0].Type())
+		}
+
+		value, ok := starlark.AsString(item[1])
+		if !ok {
+			return fmt.Errorf("header %s must be a string, got %s", key, item[1].Type())
+		}
+
+		updated[strings.ToLower(key)] = value
+	}
+
+	for key := range original {
+		if _, ok := updated[key]; !ok {
+			header.Del(key)
+		}
+	}
+
+	for key, value := range updated {
+		if original[key] != value {
+			header.Set(key, value)
+		}
+	}
+
+	return nil
+}
+
+func scriptString(dict *starlark.Dict, key string) (string, bool, error) {
+	value, found, _ := dict.Get(starlark.String(key))
+	if !found || value == starlark.None {
+		return "", false, nil
+	}
+
+	switch v := value.(type) {
+	case starlark.String:
+		return string(v), true, nil
+	case starlark.Bytes:
+		return string(v), true, nil
+	}
+
+	return "", false, fmt.Errorf("%s must be a string, got %s", key, value.Type())
+}
+
+func setRequestBody(req *http.Request, body []byte) {
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	setContentLength(req.Header, req.ContentLength)
+}
+
+func setResponseBody(resp *http.Response, body []byte) {
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	setContentLength(resp.Header, resp.ContentLength)
+}
+
+// scriptError keeps the innermost script position of the error on a single line (status bar friendly).
+func scriptError(err error) error {
+	evalErr, ok := err.(*starlark.EvalError)
+	if !ok {
+		return err
+	}
+
+	for i := len(evalErr.CallStack) - 1; i >= 0; i-- {
+		if pos := evalErr.CallStack.At(i).Pos; pos.Filename() != "<builtin>" {
+			return fmt.Errorf("%s (%s:%d)", evalErr.Msg, filepath.Base(pos.Filename()), pos.Line)
+		}
+	}
+
+	return fmt.Errorf("%s", evalErr.Msg)
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestIsScriptReadAllowed(t *testing.T) {
+	root := t.TempDir()
+	allowed := filepath.Join(root, "scripts")
+	denied := filepath.Join(root, "secrets")
+
+	for _, dir := range []string{allowed, filepath.Join(allowed, "data"), denied} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, path := range []string{filepath.Join(allowed, "data.json"), filepath.Join(allowed, "data", "maps.json"), filepath.Join(denied, "token.txt"), filepath.Join(root, "scripts-other.json")} {
+		if err := os.WriteFile(path, []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a link within the allowed directory pointing outside of it
+	link := filepath.Join(allowed, "token.txt")
+	if err := os.Symlink(filepath.Join(denied, "token.txt"), link); err != nil {
+		t.Skipf("symbolic links are not supported: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		allowRead []string
+		want      bool
+	}{
+		{"file within the directory", filepath.Join(allowed, "data.json"), []string{allowed}, true},
+		{"file within a subdirectory", filepath.Join(allowed, "data", "maps.json"), []string{allowed}, true},
+		{"file outside of the directory", filepath.Join(denied, "token.txt"), []string{allowed}, false},
+		{"traversal outside of the directory", filepath.Join(allowed, "..", "secrets", "token.txt"), []string{allowed}, false},
+		{"directory sharing the prefix", filepath.Join(root, "scripts-other.json"), []string{allowed}, false},
+		{"link pointing outside of the directory", link, []string{allowed}, false},
+		{"missing file", filepath.Join(allowed, "missing.json"), []string{allowed}, false},
+		{"no allowed directory", filepath.Join(allowed, "data.json"), nil, false},
+		{"second allowed directory", filepath.Join(denied, "token.txt"), []string{allowed, denied}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isScriptReadAllowed(test.path, test.allowRead); got != test.want {
+				t.Errorf("isScriptReadAllowed(%s) = %t, want %t", test.path, got, test.want)
+			}
+		})
+	}
+}
+
+func TestScriptMemoryLimit(t *testing.T) {
+	options := scriptOptions{maxSteps: defaultScriptMaxSteps, maxMemory: 1024 * 1024, maxBody: defaultScriptMaxBody}
+
+	tests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"small repetition", `s = "x" * 1000`, ""},
+		{"string repetition", `s = "x" * (1 << 29)`, "max_memory"},
+		{"reversed string repetition", `s = (1 << 29) * "x"`, "max_memory"},
+		{"huge repetition count", `s = "x" * (1 << 80)`, "max_memory"},
+		{"list repetition", `l = [0] * (1 << 20)`, "max_memory"},
+		{"doubling concatenation", "s = \"x\" * 1000\nfor i in range(20):\n    s = s + s", "max_memory"},
+		{"augmented concatenation", "s = \"x\" * 1000\nfor i in range(20):\n    s += s", "max_memory"},
+		{"augmented index", "def f():\n    d = {\"a\": \"x\"}\n    d[\"a\"] += \"y\" * (1 << 19)\n    d[\"a\"] *= 4\nf()", "max_memory"},
+		{"within a while loop", "while True:\n    s = \"x\" * (1 << 21)", "max_memory"},
+		{"join", `s = ",".join(["x" * 1000] * 2000)`, "max_memory"},
+		{"replace", `s = ("x" * 1000).replace("x", "y" * 2000)`, "max_memory"},
+		{"format", `s = "{}{}{}{}".format("x" * 300000)`, "max_memory"},
+		{"percent", `s = "%s%s" % ("x" * 600000, "y" * 600000)`, "max_memory"},
+		{"str", `s = str(["x" * 1000] * 2000)`, "max_memory"},
+		{"json.encode", `s = json.encode(["x" * 1000] * 2000)`, "max_memory"},
+		{"list", `l = list(range(1 << 20))`, "max_memory"},
+		{"extend", "l = []\nl.extend(range(1 << 20))", "max_memory"},
+		{"list augmented with an iterable", "l = []\nl += range(1 << 20)", "max_memory"},
+		{"usual operations", "l = [1, 2]\nl += [3]\nl.extend([4])\nx = 1\nx += 2\ns = \"a,b\".split(\",\")\nt = \"%d-%s\" % (x, s)\nd = {\"a\": 1} | {\"b\": 2}", ""},
+		{"cyclic list", "l = []\nl.append(l)\ns = str(l)", ""},
+		{"augmented call result", "d = {}\ndef k():\n    return \"a\"\nd[k()] = \"\"\nd[k()] += \"x\"", "only supported"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			program, err := compileScript("test.star", []byte(test.source), scriptPredeclared(options).Has)
+			if err == nil {
+				thread := &starlark.Thread{Name: test.name}
+				thread.SetMaxExecutionSteps(options.maxSteps)
+				_, err = program.Init(thread, scriptPredeclared(options))
+			}
+
+			if test.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestScriptResultMaxBody(t *testing.T) {
+	options := scriptOptions{maxBody: 4}
+
+	passed := starlark.NewDict(1)
+	passed.SetKey(starlark.String("body"), starlark.String("12345"))
+
+	if _, err := scriptResult("on_response", starlark.None, passed, options); err == nil {
+		t.Error("body altered in place beyond max_body was accepted")
+	}
+
+	returned := starlark.NewDict(1)
+	returned.SetKey(starlark.String("body"), starlark.String("1234"))
+
+	if _, err := scriptResult("on_response", returned, passed, options); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
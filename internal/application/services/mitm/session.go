@@ -0,0 +1,251 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"encoding/json"
+	"fmt"
+	"infinite-mitm/pkg/domains"
+	"infinite-mitm/pkg/pattern"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// extractor is an "extract" entry of a rule, saving a value of the response in the session variables.
+type extractor struct {
+	name    string
+	json    []interface{}
+	regex   *regexp.Regexp
+	header  string
+	capture int
+}
+
+// session variables are kept until the application exits, even when the mitm.yaml is saved
+var (
+	sessionVariables      = map[string]string{}
+	sessionVariablesMutex sync.RWMutex
+)
+
+var (
+	variableNameRegexp      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+	variableReferenceRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_-]*)\}`)
+	jsonPathTokenRegexp     = regexp.MustCompile(`^(?:\.([^.\[\]]+)|\[(\d+)\]|\['([^']*)'\]|\["([^"]*)"\])`)
+)
+
+func getSessionVariables() map[string]string {
+	sessionVariablesMutex.RLock()
+	defer sessionVariablesMutex.RUnlock()
+
+	variables := make(map[string]string, len(sessionVariables))
+	for k, v := range sessionVariables {
+		variables[k] = v
+	}
+
+	return variables
+}
+
+func setSessionVariable(name string, value string) {
+	sessionVariablesMutex.Lock()
+	defer sessionVariablesMutex.Unlock()
+
+	sessionVariables[name] = value
+}
+
+// replaceVariables replaces the $1 matches, the route parameters and the ${name} session variables of a rule value;
+// unknown session variables are replaced by an empty string.
+func replaceVariables(value string, matches []string) string {
+	value = pattern.ReplaceParameters(pattern.ReplaceMatches(value, matches))
+	if !strings.Contains(value, "${") {
+		return value
+	}
+
+	variables := getSessionVariables()
+	return variableReferenceRegexp.ReplaceAllStringFunc(value, func(reference string) string {
+		return variables[reference[2:len(reference) - 1]]
+	})
+}
+
+// newExtractors validates the "extract" entries of a rule; each one must use a single source.
+func newExtractors(entries []domains.YAMLDomainExtract) ([]extractor, error) {
+	extractors := make([]extractor, 0, len(entries))
+
+	for _, entry := range entries {
+		if !variableNameRegexp.MatchString(entry.Name) {
+			return nil, fmt.Errorf("invalid variable name: %q (expected letters, digits, dashes and underscores, starting with a letter)", entry.Name)
+		}
+
+		sources := 0
+		for _, set := range []bool{entry.JSON != "", entry.Regex != "", entry.Header != "", entry.Capture != 0} {
+			if set {
+				sources++
+			}
+		}
+
+		if sources != 1 {
+			return nil, fmt.Errorf("%s must use one of json, regex, header or capture", entry.Name)
+		}
+
+		item := extractor{name: entry.Name, header: entry.Header, capture: entry.Capture}
+		if entry.JSON != "" {
+			path, err := parseJSONPath(entry.JSON)
+			if err != nil {
+				return nil, fmt.Errorf("invalid json path for %s: %s", entry.Name, err.Error())
+			}
+
+			item.json = path
+		}
+
+		if entry.Regex != "" {
+			re, err := regexp.Compile(entry.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex for %s: %s", entry.Name, err.Error())
+			}
+
+			item.regex = re
+		}
+
+		if entry.Capture < 0 {
+			return nil, fmt.Errorf("invalid capture for %s: %d", entry.Name, entry.Capture)
+		}
+
+		extractors = append(extractors, item)
+	}
+
+	return extractors, nil
+}
+
+// extractVariables saves the values of the response in the session variables; values which are not found are left unchanged.
+func extractVariables(extractors []extractor, matches []string, resp *http.Response) error {
+	var body []byte
+	var bodyRead bool
+
+	for _, item := range extractors {
+		var value string
+		var found bool
+
+		switch {
+		case item.header != "":
+			value = resp.Header.Get(item.header)
+			found = value != ""
+		case item.capture != 0:
+			if item.capture <= len(matches) {
+				value, found = matches[item.capture - 1], true
+			}
+		default:
+			if !bodyRead {
+				data, original, err := readTrafficBody(resp.Body, resp.Header, 0)
+				if err != nil {
+					// the response is forwarded untouched
+					resp.Body = original
+					return err
+				}
+
+				body, bodyRead = data, true
+				setResponseBody(resp, body)
+			}
+
+			if item.json != nil {
+				value, found = extractJSON(body, item.json)
+			} else if submatches := item.regex.FindSubmatch(body); submatches != nil {
+				// the first group, or the whole match without group
+				value, found = string(submatches[len(submatches) - 1]), true
+				if len(submatches) > 1 {
+					value = string(submatches[1])
+				}
+			}
+		}
+
+		if found {
+			setSessionVariable(item.name, value)
+		}
+	}
+
+	return nil
+}
+
+// parseJSONPath splits a JSONPath (e.g., "$.lobby.players[0].xuid", "$['asset-id']") into object keys (string) and array indexes (int).
+func parseJSONPath(path string) ([]interface{}, error) {
+	rest := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	tokens := []interface{}{}
+
+	for rest != "" {
+		submatches := jsonPathTokenRegexp.FindStringSubmatch(rest)
+		if submatches == nil {
+			return nil, fmt.Errorf("unexpected %q", rest)
+		}
+
+		switch {
+		case submatches[1] != "":
+			tokens = append(tokens, submatches[1])
+		case submatches[2] != "":
+			index, _ := strconv.Atoi(submatches[2])
+			tokens = append(tokens, index)
+		case submatches[3] != "":
+			tokens = append(tokens, submatches[3])
+		default:
+			tokens = append(tokens, submatches[4])
+		}
+
+		rest = rest[len(submatches[0]):]
+	}
+
+	return tokens, nil
+}
+
+// extractJSON returns the value at path in the JSON body; objects and arrays are returned as JSON.
+func extractJSON(body []byte, path []interface{}) (string, bool) {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return "", false
+	}
+
+	for _, token := range path {
+		switch key := token.(type) {
+		case string:
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return "", false
+			}
+
+			if value, ok = object[key]; !ok {
+				return "", false
+			}
+		case int:
+			array, ok := value.([]interface{})
+			if !ok || key >= len(array) {
+				return "", false
+			}
+
+			value = array[key]
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case nil:
+		return "", true
+	}
+
+	data, err := json.Marshal(value)
+	return string(data), err == nil
+}
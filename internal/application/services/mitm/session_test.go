@@ -0,0 +1,88 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    []interface{}
+		wantErr bool
+	}{
+		{"$", []interface{}{}, false},
+		{"$.lobby.id", []interface{}{"lobby", "id"}, false},
+		{"$.lobby.players[0].xuid", []interface{}{"lobby", "players", 0, "xuid"}, false},
+		{"$['asset-id'][\"version\"]", []interface{}{"asset-id", "version"}, false},
+		{" .token ", []interface{}{"token"}, false},
+		{"$.players[-1]", nil, true},
+		{"$..id", nil, true},
+		{"$.players[", nil, true},
+		{"lobby", nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := parseJSONPath(test.path)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseJSONPath(%q) error = %v, wantErr %t", test.path, err, test.wantErr)
+			continue
+		}
+
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseJSONPath(%q) = %#v, want %#v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	body := []byte(`{"lobby":{"id":"abc","size":8,"ranked":true,"region":null,"players":[{"xuid":"2533"},{"xuid":"2534"}]},"asset-id":"42"}`)
+
+	tests := []struct {
+		path      string
+		want      string
+		wantFound bool
+	}{
+		{"$.lobby.id", "abc", true},
+		{"$.lobby.size", "8", true},
+		{"$.lobby.ranked", "true", true},
+		{"$.lobby.region", "", true},
+		{"$.lobby.players[1].xuid", "2534", true},
+		{"$.lobby.players[0]", `{"xuid":"2533"}`, true},
+		{"$['asset-id']", "42", true},
+		{"$.lobby.players[2].xuid", "", false},
+		{"$.lobby.missing", "", false},
+		{"$.lobby.id.value", "", false},
+		{"$.lobby[0]", "", false},
+	}
+
+	for _, test := range tests {
+		path, err := parseJSONPath(test.path)
+		if err != nil {
+			t.Fatalf("parseJSONPath(%q): %v", test.path, err)
+		}
+
+		got, found := extractJSON(body, path)
+		if got != test.want || found != test.wantFound {
+			t.Errorf("extractJSON(%q) = %q, %t, want %q, %t", test.path, got, found, test.want, test.wantFound)
+		}
+	}
+
+	if _, found := extractJSON([]byte("not json"), []interface{}{"id"}); found {
+		t.Errorf("extractJSON() of an invalid body found a value")
+	}
+}
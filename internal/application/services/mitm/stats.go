@@ -0,0 +1,100 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"fmt"
+	eventsService "infinite-mitm/internal/application/services/events"
+	"sync/atomic"
+	"time"
+
+	"github.com/gookit/event"
+)
+
+type dispatchStats struct {
+	lookups    atomic.Int64
+	candidates atomic.Int64
+	total      atomic.Int64
+	max        atomic.Int64
+	blocked    atomic.Int64
+}
+
+const statsReportInterval = 1 * time.Second
+
+var stats = &dispatchStats{}
+
+func resetStats() {
+	stats.lookups.Store(0)
+	stats.candidates.Store(0)
+	stats.total.Store(0)
+	stats.max.Store(0)
+	stats.blocked.Store(0)
+}
+
+func (s *dispatchStats) record(candidates int, elapsed time.Duration) {
+	s.lookups.Add(1)
+	s.candidates.Add(int64(candidates))
+	s.total.Add(int64(elapsed))
+
+	for {
+		current := s.max.Load()
+		if int64(elapsed) <= current || s.max.CompareAndSwap(current, int64(elapsed)) {
+			break
+		}
+	}
+}
+
+func (s *dispatchStats) String() string {
+	details := "rules: no lookups yet"
+	if lookups := s.lookups.Load(); lookups != 0 {
+		average := time.Duration(s.total.Load() / lookups)
+		details = fmt.Sprintf(
+			"rules: %d lookups · %.1f candidates · avg %s · max %s",
+			lookups,
+			float64(s.candidates.Load()) / float64(lookups),
+			average.Round(100 * time.Nanosecond),
+			time.Duration(s.max.Load()).Round(100 * time.Nanosecond),
+		)
+	}
+
+	if blocked := s.blocked.Load(); blocked != 0 {
+		details += fmt.Sprintf(" · %d blocked", blocked)
+	}
+
+	return details
+}
+
+func ReportStats(stopChan <-chan struct{}) {
+	ticker := time.NewTicker(statsReportInterval)
+	defer ticker.Stop()
+
+	var last string
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			details := stats.String()
+			if summary := getScenariosSummary(); summary != "" {
+				details += " | " + summary
+			}
+
+			if details != last {
+				last = details
+				event.MustFire(eventsService.ProxyStatsMessage, event.M{"details": details})
+			}
+		}
+	}
+}
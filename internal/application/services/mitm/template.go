@@ -0,0 +1,284 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// bodyTemplateData is exposed to the "body_template" of the rules (e.g., {{.Method}}, {{index .Captures 0}}, {{.Body.name}}).
+type bodyTemplateData struct {
+	Captures  []string
+	Method    string
+	URL       string
+	Host      string
+	Path      string
+	Query     url.Values
+	Headers   http.Header
+	Body      interface{}
+	RawBody   string
+	Now       time.Time
+	Vars      map[string]string
+	Scenarios map[string]scenarioSnapshot
+	Session   map[string]string
+}
+
+type compiledTemplate struct {
+	modTime time.Time
+	size    int64
+	tmpl    *template.Template
+}
+
+var (
+	userVariables      map[string]string
+	userVariablesMutex sync.RWMutex
+)
+
+var (
+	compiledTemplates      = map[string]*compiledTemplate{}
+	compiledTemplatesMutex sync.Mutex
+)
+
+var bodyTemplateFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+	"default": func(fallback interface{}, value interface{}) interface{} {
+		if value == nil || value == "" {
+			return fallback
+		}
+
+		return value
+	},
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"replace": strings.ReplaceAll,
+	"add": func(a interface{}, b interface{}) float64 {
+		return toNumber(a) + toNumber(b)
+	},
+}
+
+// toNumber converts the JSON numbers (float64), integers and numeric strings used in templates.
+func toNumber(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case string:
+		number, _ := strconv.ParseFloat(v, 64)
+		return number
+	}
+
+	return 0
+}
+
+// setUserVariables replaces the "variables" of the mitm.yaml, available as {{.Vars.name}}.
+func setUserVariables(variables map[string]string) {
+	userVariablesMutex.Lock()
+	defer userVariablesMutex.Unlock()
+
+	userVariables = variables
+}
+
+func getUserVariables() map[string]string {
+	userVariablesMutex.RLock()
+	defer userVariablesMutex.RUnlock()
+
+	variables := make(map[string]string, len(userVariables))
+	for k, v := range userVariables {
+		variables[k] = v
+	}
+
+	return variables
+}
+
+// resetBodyTemplates drops the templates parsed for the previous mitm.yaml.
+func resetBodyTemplates() {
+	compiledTemplatesMutex.Lock()
+	defer compiledTemplatesMutex.Unlock()
+
+	compiledTemplates = map[string]*compiledTemplate{}
+}
+
+// checkBodyTemplate parses a "body_template" when the mitm.yaml is loaded, so its errors are reported on startup;
+// the template files whose path is built from matches or variables (e.g., ":mitm-dir/templates/$1.json") are checked when used.
+func checkBodyTemplate(source string) error {
+	if source == "" || (!strings.Contains(source, "{{") && matchReferenceRegexp.MatchString(source)) {
+		return nil
+	}
+
+	_, err := loadBodyTemplate(source, nil)
+	return err
+}
+
+// loadBodyTemplate parses source, either an inline template or the path of a template file; files are parsed once, until they are modified.
+func loadBodyTemplate(source string, matches []string) (*template.Template, error) {
+	if strings.Contains(source, "{{") {
+		compiledTemplatesMutex.Lock()
+		defer compiledTemplatesMutex.Unlock()
+
+		if compiled, ok := compiledTemplates[source]; ok {
+			return compiled.tmpl, nil
+		}
+
+		tmpl, err := parseBodyTemplate(source)
+		if err != nil {
+			return nil, err
+		}
+
+		compiledTemplates[source] = &compiledTemplate{tmpl: tmpl}
+		return tmpl, nil
+	}
+
+	path := filepath.Clean(replaceVariables(source, matches))
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	compiledTemplatesMutex.Lock()
+	defer compiledTemplatesMutex.Unlock()
+
+	if compiled, ok := compiledTemplates[path]; ok && compiled.modTime.Equal(info.ModTime()) && compiled.size == info.Size() {
+		return compiled.tmpl, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := parseBodyTemplate(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	compiledTemplates[path] = &compiledTemplate{modTime: info.ModTime(), size: info.Size(), tmpl: tmpl}
+	return tmpl, nil
+}
+
+func parseBodyTemplate(text string) (*template.Template, error) {
+	return template.New("body_template").Funcs(bodyTemplateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// renderBodyTemplate renders source, either an inline template or the path of a template file (e.g., ":mitm-dir/templates/$1.json"),
+// against req and body, the current request body (request rules) or response body (response rules).
+func renderBodyTemplate(source string, matches []string, req *http.Request, body []byte) ([]byte, error) {
+	tmpl, err := loadBodyTemplate(source, matches)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed interface{}
+	if len(body) != 0 && json.Unmarshal(body, &parsed) != nil {
+		parsed = nil
+	}
+
+	var buffer bytes.Buffer
+	err = tmpl.Execute(&buffer, bodyTemplateData{
+		Captures:  matches,
+		Method:    req.Method,
+		URL:       req.URL.String(),
+		Host:      req.URL.Hostname(),
+		Path:      req.URL.Path,
+		Query:     req.URL.Query(),
+		Headers:   req.Header,
+		Body:      parsed,
+		RawBody:   string(body),
+		Now:       time.Now(),
+		Vars:      getUserVariables(),
+		Scenarios: getScenarioSnapshots(),
+		Session:   getSessionVariables(),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// errTrafficBodyTooLarge is returned by readTrafficBody when the body, encoded or decoded, exceeds its limit.
+var errTrafficBodyTooLarge = fmt.Errorf("body too large")
+
+// trafficBodyReplay replays the bytes already read from a body, followed by the ones left.
+type trafficBodyReplay struct {
+	io.Reader
+	io.Closer
+}
+
+// readTrafficBody reads the whole body, decoding it when gzip encoded, and removes the encoding from header; no more than
+// limit bytes (if any) are read. On error, the header is left untouched and the original body is returned to forward the traffic as is.
+func readTrafficBody(body io.ReadCloser, header http.Header, limit int64) ([]byte, io.ReadCloser, error) {
+	if body == nil || body == http.NoBody {
+		return nil, body, nil
+	}
+
+	var reader io.Reader = body
+	if limit > 0 {
+		reader = io.LimitReader(body, limit + 1)
+	}
+
+	raw, err := io.ReadAll(reader)
+	var original io.ReadCloser = &trafficBodyReplay{Reader: io.MultiReader(bytes.NewReader(raw), body), Closer: body}
+	if err != nil {
+		return nil, original, err
+	} else if limit > 0 && int64(len(raw)) > limit {
+		return nil, original, errTrafficBodyTooLarge
+	}
+
+	body.Close()
+	original = io.NopCloser(bytes.NewReader(raw))
+
+	if !strings.EqualFold(header.Get("Content-Encoding"), "gzip") {
+		return raw, nil, nil
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, original, fmt.Errorf("invalid gzip body: %s", err.Error())
+	}
+
+	defer gzipReader.Close()
+	reader = gzipReader
+	if limit > 0 {
+		reader = io.LimitReader(gzipReader, limit + 1)
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, original, fmt.Errorf("invalid gzip body: %s", err.Error())
+	} else if limit > 0 && int64(len(data)) > limit {
+		return nil, original, errTrafficBodyTooLarge
+	}
+
+	header.Del("Content-Encoding")
+	return data, nil, nil
+}
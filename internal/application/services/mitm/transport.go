@@ -0,0 +1,223 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationMITMService
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	helpers "infinite-mitm/internal/application/services/mitm/helpers"
+	"infinite-mitm/pkg/mitm"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/elazarl/goproxy"
+	xproxy "golang.org/x/net/proxy"
+)
+
+const (
+	defaultDialTimeout           = 30 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultResponseHeaderTimeout = 30 * time.Second
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultMaxIdleConns          = 100
+	defaultMaxIdleConnsPerHost   = 32
+)
+
+// resolvingDialer dials the services directly, replacing the hostnames listed in the "transport.resolve" options by their IP address.
+type resolvingDialer struct {
+	dialer  *net.Dialer
+	resolve map[string]string
+}
+
+func (d *resolvingDialer) Dial(network string, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d *resolvingDialer) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	return d.dialer.DialContext(ctx, network, d.resolveAddr(addr))
+}
+
+func (d *resolvingDialer) resolveAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	if ip, ok := d.resolve[strings.ToLower(host)]; ok {
+		return net.JoinHostPort(ip, port)
+	}
+
+	return addr
+}
+
+// configureTransport applies the "transport" and "upstream" options of the mitm.yaml to the connections made by the proxy,
+// both for the decrypted requests (proxy.Tr) and the tunnelled hosts (proxy.ConnectDial); the direct dialer is returned
+// for the connections which must not go through the upstream proxy.
+func configureTransport(proxy *goproxy.ProxyHttpServer, options mitm.YAMLOptions) (*resolvingDialer, error) {
+	transport := options.Transport
+	dialer := &resolvingDialer{
+		dialer:  &net.Dialer{Timeout: parseTimeout(transport.DialTimeout, defaultDialTimeout), KeepAlive: 30 * time.Second},
+		resolve: map[string]string{},
+	}
+
+	for host, ip := range transport.Resolve {
+		if net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("invalid IP address for %s in transport.resolve: %s", host, ip)
+		}
+
+		dialer.resolve[strings.ToLower(host)] = ip
+	}
+
+	tr := proxy.Tr
+	tr.DialContext = dialer.DialContext
+	// goproxy dials the upstream proxy of the tunnelled hosts through the (deprecated) Dial function
+	tr.Dial = dialer.Dial
+	tr.TLSHandshakeTimeout = parseTimeout(transport.TLSHandshakeTimeout, defaultTLSHandshakeTimeout)
+	tr.ResponseHeaderTimeout = parseTimeout(transport.ResponseHeaderTimeout, defaultResponseHeaderTimeout)
+	tr.IdleConnTimeout = parseTimeout(transport.IdleConnTimeout, defaultIdleConnTimeout)
+	tr.ExpectContinueTimeout = 1 * time.Second
+	tr.MaxIdleConns = positiveOrDefault(transport.MaxIdleConns, defaultMaxIdleConns)
+	tr.MaxIdleConnsPerHost = positiveOrDefault(transport.MaxIdleConnsPerHost, defaultMaxIdleConnsPerHost)
+	tr.MaxConnsPerHost = transport.MaxConnsPerHost
+
+	upstream, err := parseUpstream(options.Upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	// without upstream, the HTTP(S)_PROXY environment variables remain honored
+	if upstream == nil {
+		if proxy.ConnectDial == nil {
+			proxy.ConnectDial = dialer.Dial
+		}
+
+		return dialer, nil
+	}
+
+	noProxy := helpers.NewInterceptHosts(options.Upstream.NoProxy)
+	bypass := func(addr string) bool {
+		host, _, err := net.SplitHostPort(addr)
+		return err == nil && noProxy.Matches(host)
+	}
+
+	switch upstream.Scheme {
+	case "http", "https":
+		tr.Proxy = func(req *http.Request) (*url.URL, error) {
+			if noProxy.Matches(req.URL.Hostname()) {
+				return nil, nil
+			}
+
+			return upstream, nil
+		}
+
+		connect := proxy.NewConnectDialToProxyWithHandler(upstream.String(), func(req *http.Request) {
+			if upstream.User != nil {
+				password, _ := upstream.User.Password()
+				credentials := base64.StdEncoding.EncodeToString([]byte(upstream.User.Username() + ":" + password))
+				req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+			}
+		})
+
+		proxy.ConnectDial = func(network string, addr string) (net.Conn, error) {
+			if bypass(addr) {
+				return dialer.Dial(network, addr)
+			}
+
+			return connect(network, dialer.resolveAddr(addr))
+		}
+	case "socks5", "socks5h":
+		var auth *xproxy.Auth
+		if upstream.User != nil {
+			password, _ := upstream.User.Password()
+			auth = &xproxy.Auth{User: upstream.User.Username(), Password: password}
+		}
+
+		socks, err := xproxy.SOCKS5("tcp", upstream.Host, auth, dialer)
+		if err != nil {
+			return nil, err
+		}
+
+		socksDialer := socks.(xproxy.ContextDialer)
+		tr.Proxy = nil
+		tr.DialContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			if bypass(addr) {
+				return dialer.DialContext(ctx, network, addr)
+			}
+
+			return socksDialer.DialContext(ctx, network, dialer.resolveAddr(addr))
+		}
+
+		tr.Dial = nil
+		proxy.ConnectDial = func(network string, addr string) (net.Conn, error) {
+			return tr.DialContext(context.Background(), network, addr)
+		}
+	}
+
+	return dialer, nil
+}
+
+// parseUpstream returns the upstream proxy URL, with its credentials, or nil when none is configured.
+func parseUpstream(options mitm.YAMLUpstreamOptions) (*url.URL, error) {
+	if options.URL == "" {
+		return nil, nil
+	}
+
+	upstream, err := url.Parse(options.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream url: %s", err.Error())
+	}
+
+	switch upstream.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported upstream scheme: %s (expected http, https or socks5)", upstream.Scheme)
+	}
+
+	if upstream.Hostname() == "" {
+		return nil, fmt.Errorf("invalid upstream url: missing host")
+	}
+
+	if upstream.Port() == "" {
+		ports := map[string]string{"http": "80", "https": "443", "socks5": "1080", "socks5h": "1080"}
+		upstream.Host = net.JoinHostPort(upstream.Hostname(), ports[upstream.Scheme])
+	}
+
+	if options.Username != "" {
+		upstream.User = url.UserPassword(options.Username, options.Password)
+	}
+
+	return upstream, nil
+}
+
+func parseTimeout(value string, fallback time.Duration) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return fallback
+	}
+
+	return duration
+}
+
+func positiveOrDefault(value int, fallback int) int {
+	if value <= 0 {
+		return fallback
+	}
+
+	return value
+}
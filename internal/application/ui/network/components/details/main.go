@@ -40,6 +40,7 @@ type DetailsModel struct {
 	trafficID     string
 	requestMethod string
 	requestURL    string
+	requestClient string
 
 	responseStatusCode  int
 
@@ -49,15 +50,19 @@ type DetailsModel struct {
 }
 
 type RequestTraffic struct {
-	ID      string
-	Headers map[string] string
-	Body    []byte
+	ID       string
+	Headers  map[string] string
+	Body     []byte
+	Size     int64
+	Commands string
 }
 
 type ResponseTraffic struct {
-	ID      string
-	Headers map[string] string
-	Body    []byte
+	ID       string
+	Headers  map[string] string
+	Body     []byte
+	Size     int64
+	Commands string
 }
 
 type ResponseStatus struct {
@@ -96,6 +101,7 @@ var (
 	ongoingString      = "Ongoing"
 	windowHeightString = "[ Please increase the window height ]"
 	copiedString       = "✓ Copied"
+	clientString       = "Client: %s"
 	switchString       = "Tab ↹: Switch"
 
 	copyUrlString      = fmt.Sprintf("%s: Copy URL to clipboard", CopyUrlCommand)
@@ -164,6 +170,10 @@ func (m *DetailsModel) SetRequestInfo(requestURL string, requestMethod string) {
 	m.requestMethod = requestMethod
 }
 
+func (m *DetailsModel) SetRequestClient(client string) {
+	m.requestClient = client
+}
+
 func (m *DetailsModel) SetResponseStatusCode(statusCode int) {
 	m.responseStatusCode = statusCode
 }
@@ -212,13 +222,13 @@ func (m DetailsModel) Update(msg tea.Msg) (DetailsModel, tea.Cmd) {
 	switch msg := msg.(type) {
 	case RequestTraffic:
 		if m.focused && msg.ID == m.trafficID {
-			m.SetRequestTrafficData(&traffic.TrafficData{Headers: msg.Headers, Body: msg.Body})
+			m.SetRequestTrafficData(&traffic.TrafficData{Headers: msg.Headers, Body: msg.Body, Size: msg.Size, Commands: msg.Commands})
 		}
 
 		return m, tea.Batch(cmds...)
 	case ResponseTraffic:
 		if m.focused && msg.ID == m.trafficID {
-			m.SetResponseTrafficData(&traffic.TrafficData{Headers: msg.Headers, Body: msg.Body})
+			m.SetResponseTrafficData(&traffic.TrafficData{Headers: msg.Headers, Body: msg.Body, Size: msg.Size, Commands: msg.Commands})
 		}
 
 		return m, tea.Batch(cmds...)
@@ -267,6 +277,9 @@ func (m DetailsModel) View() string {
 		urlContent := theme.StatusCodeToColorStyle(statusCode).Render(url)
 		statusTextContent := theme.StatusCodeToColorStyle(statusCode).Render(statusText)
 		details := method + " " + "[" + statusTextContent + "]" + " " + utilities.WrapText(urlContent, m.width - 2)
+		if m.requestClient != "" {
+			details += "\n" + lipgloss.NewStyle().Foreground(theme.ColorGrey).Render(fmt.Sprintf(clientString, m.requestClient))
+		}
 		copyElement := copyUrlString
 		if m.copyPressed {
 			copyElement = copiedString
@@ -37,14 +37,17 @@ type TrafficData struct {
 	Dummy     bool
 	Headers   map[string]string
 	Body      []byte
+	Size      int64
 	URL       string
+	Commands  string
 }
 
 type TrafficModel struct {
 	width int
 
-	headersModel viewport.Model
-	bodyModel    viewport.Model
+	headersModel  viewport.Model
+	bodyModel     viewport.Model
+	commandsModel viewport.Model
 
 	data        *TrafficData
 	activeView  activeViewType
@@ -55,8 +58,9 @@ type TrafficModel struct {
 const BodyMaxViewLength = 100 * 1024
 
 const (
-	HeadersViewKey activeViewType = "headers"
-	BodyViewKey    activeViewType = "body"
+	HeadersViewKey  activeViewType = "headers"
+	BodyViewKey     activeViewType = "body"
+	CommandsViewKey activeViewType = "commands"
 )
 
 const (
@@ -72,8 +76,10 @@ var (
 	waitingString      = "Waiting..."
 	copiedString       = "✓ Copied"
 	bodyTooLargeString = "Content too large to be displayed"
+	truncatedString    = "Preview limited to %d of %d bytes"
 
 	switchHintString   = "Enter ↵: Switch between headers and body"
+	commandsHintString = "Enter ↵: Switch between headers, body and commands"
 	scrollHintString   = "↑/↓: Scroll"
 
 	copyHeadersString  = fmt.Sprintf("%s: Copy headers to clipboard", CopyHeadersCommand)
@@ -99,13 +105,16 @@ var (
 func NewTrafficDetailsModel(width int, height int) TrafficModel {
 	hvp := viewport.New(width, height)
 	bvp := viewport.New(width, height)
+	cvp := viewport.New(width, height)
 
 	hvp.SetContent("...")
 	bvp.SetContent("...")
+	cvp.SetContent("...")
 
 	m := TrafficModel{
-		headersModel: hvp,
-		bodyModel:    bvp,
+		headersModel:  hvp,
+		bodyModel:     bvp,
+		commandsModel: cvp,
 		activeView:   HeadersViewKey,
 		data:         &TrafficData{},
 		width:        width,
@@ -129,6 +138,8 @@ func (m *TrafficModel) Blur() {
 		m.headersModel.SetYOffset(0)
 	} else if m.activeView == BodyViewKey {
 		m.bodyModel.SetYOffset(0)
+	} else if m.activeView == CommandsViewKey {
+		m.commandsModel.SetYOffset(0)
 	}
 
 	m.setContent(map[string]string{}, nil)
@@ -138,6 +149,7 @@ func (m *TrafficModel) SetWidth(width int) {
 	m.width = width
 	m.headersModel.Width = width - 20
 	m.bodyModel.Width = width - 20
+	m.commandsModel.Width = width - 20
 }
 
 func (m *TrafficModel) SetActiveView(key activeViewType) {
@@ -148,6 +160,8 @@ func (m *TrafficModel) SetActiveView(key activeViewType) {
 		m.setContent(m.data.Headers, m.data.Body)
 	} else if key == BodyViewKey {
 		m.setContent(m.data.Headers, m.data.Body)
+	} else if key == CommandsViewKey {
+		m.setContent(m.data.Headers, m.data.Body)
 	}
 }
 
@@ -157,6 +171,9 @@ func (m *TrafficModel) SetTrafficData(data *TrafficData) {
 	}
 
 	m.data = data
+	if m.activeView == CommandsViewKey && m.data.Commands == "" {
+		m.activeView = HeadersViewKey
+	}
 
 	if m.focused {
 		m.setContent(m.data.Headers, m.data.Body)
@@ -191,13 +208,18 @@ func (m *TrafficModel) setContent(headers map[string]string, body []byte) {
 		}
 
 		m.bodyModel.GotoTop()
+	} else if m.activeView == CommandsViewKey {
+		m.commandsModel.SetContent(utilities.WrapText(m.data.Commands, m.commandsModel.Width))
+		m.commandsModel.GotoTop()
 	}
 }
 
 func (m *TrafficModel) switchActiveView() {
 	if m.activeView == HeadersViewKey {
 		m.SetActiveView(BodyViewKey)
-	} else if m.activeView == BodyViewKey {
+	} else if m.activeView == BodyViewKey && m.data.Commands != "" {
+		m.SetActiveView(CommandsViewKey)
+	} else {
 		m.SetActiveView(HeadersViewKey)
 	}
 
@@ -289,6 +311,11 @@ func (m TrafficModel) Update(msg tea.Msg) (TrafficModel, tea.Cmd) {
 		cmds = append(cmds, cmd)
 	}
 
+	if m.activeView == CommandsViewKey {
+		m.commandsModel, cmd = m.commandsModel.Update(msg)
+		cmds = append(cmds, cmd)
+	}
+
 	return m, tea.Batch(cmds...)
 }
 
@@ -302,6 +329,13 @@ func (m TrafficModel) View() string {
 		sectionTitle = "Headers"
 	} else if m.activeView == BodyViewKey {
 		sectionTitle = "Body"
+	} else if m.activeView == CommandsViewKey {
+		sectionTitle = "Commands"
+	}
+
+	switchHint := switchHintString
+	if m.data.Commands != "" {
+		switchHint = commandsHintString
 	}
 
 	viewportActionsList := []string{"q: Go back"}
@@ -309,7 +343,7 @@ func (m TrafficModel) View() string {
 
 	headersLength := len(m.data.Headers)
 	bodyLength := len(m.data.Body)
-	hasData := headersLength != 0 || bodyLength != 0
+	hasData := headersLength != 0 || bodyLength != 0 || m.data.Commands != ""
 
 	if !hasData || m.data.Dummy {
 		contentStyle = emptyContentStyle
@@ -349,7 +383,12 @@ func (m TrafficModel) View() string {
 				}
 
 				viewportActionsList = append(viewportActionsList, saveBodyString)
+				if m.data.Size > int64(bodyLength) {
+					viewportActionsList = append(viewportActionsList, fmt.Sprintf(truncatedString, bodyLength, m.data.Size))
+				}
 			}
+		case CommandsViewKey:
+			content = m.commandsModel.View()
 		}
 	}
 
@@ -369,7 +408,7 @@ func (m TrafficModel) View() string {
 				lipgloss.NewStyle().
 					MarginLeft(2).
 					Foreground(theme.ColorGrey).
-					Render(switchHintString),
+					Render(switchHint),
 			),
 			contentStyle.Render(content),
 			lipgloss.NewStyle().
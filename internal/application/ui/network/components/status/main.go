@@ -26,14 +26,19 @@ type StatusBarModel struct {
 	Width int
 	Height int
 
-	name ItemProperties
-	info ItemProperties
+	name  ItemProperties
+	info  ItemProperties
+	stats ItemProperties
 }
 
 type StatusBarInfoUpdate struct {
 	Message string
 }
 
+type StatusBarStatsUpdate struct {
+	Message string
+}
+
 type ItemProperties struct {
 	content string
 	style   lipgloss.Style
@@ -52,6 +57,11 @@ var (
 		Padding(0, 1)
 
 	statusInfoStyle = lipgloss.NewStyle().Inherit(statusBarStyle).MarginLeft(2)
+
+	statusStatsStyle = lipgloss.NewStyle().
+		Inherit(statusBarStyle).
+		Foreground(theme.ColorGrey).
+		MarginLeft(2)
 )
 
 var (
@@ -68,6 +78,10 @@ func NewStatusBarModel(width int) StatusBarModel {
 			content: loadingString,
 			style: statusInfoStyle,
 		},
+		stats: ItemProperties{
+			content: "",
+			style: statusStatsStyle,
+		},
 	}
 
 	m.Width = width
@@ -84,21 +98,33 @@ func (m *StatusBarModel) SetInfoContent(text string) {
 	m.info.content = text
 }
 
+func (m *StatusBarModel) SetStatsContent(text string) {
+	m.stats.content = text
+}
+
 func (m StatusBarModel) Update(msg tea.Msg) (StatusBarModel, tea.Cmd) {
 	var cmd tea.Cmd
 	switch msg := msg.(type) {
 	case StatusBarInfoUpdate:
 		m.SetInfoContent(msg.Message)
+	case StatusBarStatsUpdate:
+		m.SetStatsContent(msg.Message)
 	}
 
 	return m, cmd
 }
 
 func (m StatusBarModel) View() string {
-	bar := lipgloss.JoinHorizontal(lipgloss.Top,
+	items := []string{
 		m.name.style.Render(m.name.content),
 		m.info.style.Render(m.info.content),
-	)
+	}
+
+	if m.stats.content != "" {
+		items = append(items, m.stats.style.Render(m.stats.content))
+	}
+
+	bar := lipgloss.JoinHorizontal(lipgloss.Top, items...)
 
 	return statusBarStyle.
 		Width(m.Width).
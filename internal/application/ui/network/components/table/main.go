@@ -31,14 +31,21 @@ type TableModel struct {
 
 	tableModel table.Model
 
+	rows         []table.Row
+	clients      []string
+	clientFilter string
+
 	rowPositionIDMap map[string]int
 	focused   bool
 }
 
+const FilterClientCommand = "ctrl+f"
+
 type TableRowMsg   struct {
 	ID           string
 	Prefix       string
 	Method       string
+	Client       string
 	Host         string
 	PathAndQuery string
 
@@ -58,7 +65,7 @@ func NewNetworkModel(height int) TableModel {
 }
 
 func CreateColums(width int) []table.Column {
-	columnWidths := []int{2, 5, 8, 8, 25, 35}
+	columnWidths := []int{2, 5, 8, 8, 10, 22, 30}
 	remainingWidth := width
 	for _, width := range columnWidths {
 		remainingWidth -= width
@@ -75,9 +82,10 @@ func CreateColums(width int) []table.Column {
 		{Title: "#", Width: actualWidths[1]},
 		{Title: "Method", Width: actualWidths[2]},
 		{Title: "Result", Width: actualWidths[3]},
-		{Title: "Host", Width: actualWidths[4]},
-		{Title: "Path", Width: actualWidths[5]},
-		{Title: "Content Type", Width: actualWidths[6]},
+		{Title: "Client", Width: actualWidths[4]},
+		{Title: "Host", Width: actualWidths[5]},
+		{Title: "Path", Width: actualWidths[6]},
+		{Title: "Content Type", Width: actualWidths[7]},
 	}
 
 	return columns
@@ -115,11 +123,60 @@ func (m TableModel) GetRowPositionMap() map[string]int {
 }
 
 func (m *TableModel) PruneRows() {
+	m.rows = nil
+	m.clients = nil
+	m.clientFilter = ""
 	m.rowPositionIDMap = map[string]int{}
 	m.tableModel.SetRows([]table.Row{})
 	m.tableModel.SetCursor(0)
 }
 
+// CycleClientFilter only shows the rows of the next known client, then all of them again.
+func (m *TableModel) CycleClientFilter() {
+	next := ""
+	if m.clientFilter == "" {
+		if len(m.clients) != 0 {
+			next = m.clients[0]
+		}
+	} else {
+		for i, client := range m.clients {
+			if client == m.clientFilter && i + 1 < len(m.clients) {
+				next = m.clients[i + 1]
+				break
+			}
+		}
+	}
+
+	m.clientFilter = next
+	m.tableModel.SetRows(m.visibleRows())
+	m.tableModel.SetCursor(0)
+}
+
+func (m TableModel) visibleRows() []table.Row {
+	if m.clientFilter == "" {
+		return m.rows
+	}
+
+	rows := []table.Row{}
+	for _, row := range m.rows {
+		if row[4] == m.clientFilter {
+			rows = append(rows, row)
+		}
+	}
+
+	return rows
+}
+
+func (m *TableModel) addClient(client string) {
+	for _, known := range m.clients {
+		if known == client {
+			return
+		}
+	}
+
+	m.clients = append(m.clients, client)
+}
+
 func (m *TableModel) draw(width int) {
 	if m.ready {
 		return
@@ -165,7 +222,6 @@ func (m TableModel) Update(msg tea.Msg) (TableModel, tea.Cmd) {
 		id := msg.ID
 		prefix := msg.Prefix
 		position := m.GetRowPosition(id)
-		rows := m.tableModel.Rows()
 
 		statusCode := "..."
 		if msg.Status != 0 {
@@ -178,26 +234,30 @@ func (m TableModel) Update(msg tea.Msg) (TableModel, tea.Cmd) {
 		}
 
 		if position < 0 {
-			nextPosition := len(rows) + 1
+			nextPosition := len(m.rows) + 1
 			m.rowPositionIDMap[id] = nextPosition
+			m.addClient(msg.Client)
 
-			m.tableModel.SetRows(append(rows, table.Row([]string{
+			m.rows = append(m.rows, table.Row([]string{
 				prefix,
 				fmt.Sprintf("%d", nextPosition),
 				msg.Method,
 				statusCode,
+				msg.Client,
 				msg.Host,
 				msg.PathAndQuery,
 				contentType,
-			})))
+			}))
 
+			m.tableModel.SetRows(m.visibleRows())
 			break
 		}
 
 		index := position - 1
-		rows[index][0] = prefix
-		rows[index][3] = statusCode
-		rows[index][6] = contentType
+		m.rows[index][0] = prefix
+		m.rows[index][3] = statusCode
+		m.rows[index][7] = contentType
+		m.tableModel.SetRows(m.visibleRows())
 	}
 
 	if m.ready {
@@ -208,6 +268,18 @@ func (m TableModel) Update(msg tea.Msg) (TableModel, tea.Cmd) {
 }
 
 func (m TableModel) View() string {
+	if m.clientFilter != "" {
+		filter := lipgloss.NewStyle().
+			Foreground(theme.ColorGrey).
+			Render(fmt.Sprintf("Client: %s (%s: next client)", m.clientFilter, FilterClientCommand))
+
+		return lipgloss.NewStyle().
+			Height(m.height).
+			MaxHeight(m.height).
+			Margin(0, 2, 1).
+			Render(lipgloss.JoinVertical(lipgloss.Left, filter, m.tableModel.View()))
+	}
+
 	return lipgloss.NewStyle().
 		Height(m.height).
 		MaxHeight(m.height).
@@ -23,11 +23,13 @@ import (
 	"infinite-mitm/pkg/errors"
 	"infinite-mitm/pkg/request"
 	"infinite-mitm/pkg/sysutilities"
+	"fmt"
 	"log"
 	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -61,6 +63,10 @@ const (
 	StopCommand      = "ctrl+c"
 	EnterCommand     = "enter"
 	PruneRowsCommand = "ctrl+r"
+	ChaosCommand     = "ctrl+t"
+	HitsCommand      = "ctrl+n"
+	ResetHitsCommand = "ctrl+x"
+	ScenariosCommand = "ctrl+e"
 )
 
 const (
@@ -138,6 +144,13 @@ func Create() {
 
 			return nil
 		}), event.Normal)
+
+		event.On(eventsService.ProxyStatsMessage, event.ListenerFunc(func(e event.Event) error {
+			details := e.Data()["details"].(string)
+			updateStatusBarStats(details)
+
+			return nil
+		}), event.Normal)
 	})
 
 	if _, err := program.Run(); err != nil {
@@ -225,20 +238,24 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.networkDetailsModel.SetID(v)
 				emptyRequestData := true
 
+				m.networkDetailsModel.SetRequestClient("")
+
 				if req, exists := networkData.Requests[v]; exists {
 					m.networkDetailsModel.SetRequestInfo(req.URL, req.Method)
-					trafficData := traffic.TrafficData{Headers: req.Headers, Body: req.Body}
+					m.networkDetailsModel.SetRequestClient(clientLabel(req.ClientAddress, req.ClientName))
+					trafficData := traffic.TrafficData{Headers: req.Headers, Body: req.Body, Size: req.BodySize, Commands: formatCommandResults(req.Commands)}
 					m.networkDetailsModel.SetRequestTrafficData(&trafficData)
 					emptyRequestData = false
 				}
 
 				if resp, exists := networkData.Responses[v]; exists {
 					m.networkDetailsModel.SetResponseStatusCode(resp.Status)
-					trafficData := traffic.TrafficData{Headers: resp.Headers, Body: resp.Body}
+					trafficData := traffic.TrafficData{Headers: resp.Headers, Body: resp.Body, Size: resp.BodySize, Commands: formatCommandResults(resp.Commands)}
 					m.networkDetailsModel.SetResponseTrafficData(&trafficData)
 
 					if emptyRequestData {
 						m.networkDetailsModel.SetRequestInfo(resp.URL, resp.Method)
+						m.networkDetailsModel.SetRequestClient(clientLabel(resp.ClientAddress, resp.ClientName))
 						m.networkDetailsModel.SetRequestTrafficData(&traffic.TrafficData{Dummy: true})
 					}
 				} else {
@@ -254,6 +271,24 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case PruneRowsCommand:
 			pruneNetworkData()
 			m.networkTableModel.PruneRows()
+			return m, tea.Batch(cmds...)
+		case ChaosCommand:
+			event.MustFire(eventsService.ToggleChaos, event.M{})
+			return m, tea.Batch(cmds...)
+		case HitsCommand:
+			event.MustFire(eventsService.ReportRuleHits, event.M{})
+			return m, tea.Batch(cmds...)
+		case ResetHitsCommand:
+			event.MustFire(eventsService.ResetRuleHits, event.M{})
+			return m, tea.Batch(cmds...)
+		case ScenariosCommand:
+			event.MustFire(eventsService.ResetScenarios, event.M{})
+			return m, tea.Batch(cmds...)
+		case table.FilterClientCommand:
+			if m.isElementActive(NetworkElementKey) {
+				m.networkTableModel.CycleClientFilter()
+			}
+
 			return m, tea.Batch(cmds...)
 		}
 	}
@@ -323,12 +358,15 @@ func pushNetworkData(data eventsService.ProxyRequestEventData) {
 		ID: data.ID,
 		Headers: data.Headers,
 		Body: data.Body,
+		Size: data.BodySize,
+		Commands: formatCommandResults(data.Commands),
 	}))
 
 	sendUI(table.TableRowMsg(table.TableRowMsg{
 		ID: data.ID,
 		Prefix: prefix,
 		Method: data.Method,
+		Client: clientLabel(data.ClientAddress, data.ClientName),
 		Host: hostname,
 		PathAndQuery: path,
 	}))
@@ -376,12 +414,15 @@ func updateNetworkData(data eventsService.ProxyResponseEventData) {
 		ID: data.ID,
 		Headers: data.Headers,
 		Body: data.Body,
+		Size: data.BodySize,
+		Commands: formatCommandResults(data.Commands),
 	}))
 
 	sendUI(table.TableRowMsg(table.TableRowMsg{
 		ID: data.ID,
 		Prefix: prefix,
 		Method: data.Method,
+		Client: clientLabel(data.ClientAddress, data.ClientName),
 		Host: hostname,
 		PathAndQuery: path,
 		Status: data.Status,
@@ -395,6 +436,39 @@ func updateStatusBar(message string) {
 	}))
 }
 
+func updateStatusBarStats(message string) {
+	sendUI(status.StatusBarStatsUpdate(status.StatusBarStatsUpdate{
+		Message: message,
+	}))
+}
+
+// formatCommandResults renders the commands of a rule, with their exit code, duration and output, for the traffic details.
+func formatCommandResults(results []eventsService.CommandResult) string {
+	var lines []string
+	for i, result := range results {
+		line := fmt.Sprintf("#%d [%s] %s\nexit code: %d | duration: %s", i + 1, result.Stage, result.Command, result.ExitCode, result.Duration.Round(time.Millisecond))
+		if result.Error != "" {
+			line += "\nerror: " + result.Error
+		}
+
+		if output := strings.TrimSpace(result.Output); output != "" {
+			line += "\n" + output
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n\n")
+}
+
+func clientLabel(address string, name string) string {
+	if name != "" {
+		return name
+	}
+
+	return address
+}
+
 func explodeURL(value string) (string, string) {
 	parse, err := url.Parse(value)
 	if err != nil {
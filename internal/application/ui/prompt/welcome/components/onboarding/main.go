@@ -0,0 +1,79 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package MITMApplicationWelcomePromptUIOnboardingComponent
+
+import (
+	"fmt"
+	"infinite-mitm/pkg/certificate"
+	"infinite-mitm/pkg/mitm"
+	"infinite-mitm/pkg/onboarding"
+	"infinite-mitm/pkg/sysutilities"
+	"infinite-mitm/pkg/theme"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/skip2/go-qrcode"
+)
+
+func Run() {
+	sysutilities.ClearTerminal()
+
+	lanURL := onboarding.GetLANURL()
+	fmt.Println(lipgloss.NewStyle().Bold(true).Render("Set up other devices"))
+	fmt.Println()
+	fmt.Printf("Once the proxy server is started, open %s from a device using the proxy,\n", onboarding.GetURL())
+	fmt.Printf("or %s from any device on the same network:\n\n", lanURL)
+
+	if qr := getQRCode(lanURL); qr != "" {
+		fmt.Println(qr)
+	}
+
+	fmt.Printf("Other devices can only reach the proxy when \"server.bind\" is set to \"0.0.0.0\" in your %s.\n", mitm.MITMFilename)
+
+	if cert, mitmErr := certificate.Read(); mitmErr == nil {
+		fmt.Printf("Fingerprint (SHA-256): %s\n", certificate.Fingerprint(cert))
+		fmt.Printf("Expires on: %s\n\n", cert.NotAfter.Format(time.DateOnly))
+	}
+
+	huh.NewConfirm().
+		Title("Make sure the fingerprint displayed on the device matches the one above.").
+		Affirmative("Go back").
+		Negative("").
+		WithTheme(theme.ThemeMITM()).
+		Run()
+}
+
+// Description returns the onboarding URL and its QR code, displayed on the welcome prompt so other devices can be set up at a glance.
+func Description() string {
+	lanURL := onboarding.GetLANURL()
+	description := fmt.Sprintf("Set up other devices: scan the QR code or open %s once the proxy server is started", lanURL)
+
+	if qr := getQRCode(lanURL); qr != "" {
+		description += "\n\n" + strings.TrimRight(qr, "\n")
+	}
+
+	return description
+}
+
+func getQRCode(url string) string {
+	qr, err := qrcode.New(url, qrcode.Low)
+	if err != nil {
+		return ""
+	}
+
+	return qr.ToSmallString(false)
+}
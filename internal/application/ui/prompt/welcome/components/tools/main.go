@@ -15,11 +15,19 @@
 package MITMApplicationWelcomePromptUIToolsComponent
 
 import (
+	"fmt"
 	selectServersUI "infinite-mitm/internal/application/ui/tools/select-servers"
+	"infinite-mitm/pkg/certificate"
+	"infinite-mitm/pkg/errors"
 	"infinite-mitm/pkg/proxy"
 	"infinite-mitm/pkg/smartcache"
 	"infinite-mitm/pkg/spinner"
+	"infinite-mitm/pkg/sysutilities"
 	"infinite-mitm/pkg/theme"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
 
 	"github.com/charmbracelet/huh"
 )
@@ -30,6 +38,9 @@ const (
 	SelectServers PromptOption = iota
 	ForceKillProxy
 	ClearSmartCache
+	RegenerateRootCertificate
+	RotateRootCertificate
+	InstallWineRootCertificate
 	GoBack
 )
 
@@ -37,6 +48,9 @@ var optionToString = map[PromptOption]string{
 	SelectServers:   "→ Select Servers",
 	ForceKillProxy:  "→ Force Kill Proxy",
 	ClearSmartCache: "→ Clear SmartCache",
+	RegenerateRootCertificate: "→ Regenerate Root Certificate",
+	RotateRootCertificate:     "→ Rotate Root Certificate",
+	InstallWineRootCertificate: "→ Install Root Certificate in Wine Prefix",
 	GoBack:          "← Go back",
 }
 
@@ -48,7 +62,8 @@ func (d PromptOption) Is(option string) bool {
 	return d.String() == option
 }
 
-func Run() {
+// Run shows the tools and reports whether the root certificate has been replaced.
+func Run() bool {
 	var selected string
 	var options []huh.Option[string]
 
@@ -57,9 +72,16 @@ func Run() {
 		huh.NewOption(SelectServers.String(), SelectServers.String()),
 		huh.NewOption(ForceKillProxy.String(), ForceKillProxy.String()),
 		huh.NewOption(ClearSmartCache.String(), ClearSmartCache.String()),
-		huh.NewOption(GoBack.String(), GoBack.String()),
+		huh.NewOption(RegenerateRootCertificate.String(), RegenerateRootCertificate.String()),
+		huh.NewOption(RotateRootCertificate.String(), RotateRootCertificate.String()),
 	)
 
+	if runtime.GOOS == "linux" {
+		options = append(options, huh.NewOption(InstallWineRootCertificate.String(), InstallWineRootCertificate.String()))
+	}
+
+	options = append(options, huh.NewOption(GoBack.String(), GoBack.String()))
+
 	huh.NewSelect[string]().
 		Title("Tools").
 		Options(options...).
@@ -75,5 +97,96 @@ func Run() {
 		proxy.ToggleProxy("off")
 	} else if SelectServers.Is(selected) {
 		selectServersUI.Create()
+	} else if RegenerateRootCertificate.Is(selected) {
+		spinner.Run("Regenerating root certificate...")
+		showRootCertificateResult(regenerateRootCertificate(false))
+		return true
+	} else if RotateRootCertificate.Is(selected) {
+		spinner.Run("Rotating root certificate...")
+		showRootCertificateResult(regenerateRootCertificate(true))
+		return true
+	} else if InstallWineRootCertificate.Is(selected) {
+		installWineRootCertificate()
+	}
+
+	return false
+}
+
+// regenerateRootCertificate replaces the root CA and removes the previous one from the trust store;
+// when install is true (rotation), the previous one is only removed once the new one is trusted.
+func regenerateRootCertificate(install bool) *errors.MITMError {
+	previous, _ := certificate.Read()
+
+	if mitmErr := certificate.Generate(); mitmErr != nil {
+		return mitmErr
+	}
+
+	if install {
+		if mitmErr := sysutilities.InstallRootCertificate(certificate.GetCERPath()); mitmErr != nil {
+			return mitmErr
+		}
+
+		current, mitmErr := certificate.Read()
+		if mitmErr != nil {
+			return mitmErr
+		}
+
+		if installed, mitmErr := sysutilities.CheckForRootCertificate(current); mitmErr != nil || !installed {
+			return errors.Create(errors.ErrProxyCertificateException, "the new root certificate could not be trusted; the previous one has been kept in your trust store")
+		}
+	}
+
+	if previous != nil {
+		sysutilities.UninstallRootCertificate(previous)
+	}
+
+	return nil
+}
+
+// installWineRootCertificate asks for a Wine or Proton prefix and trusts the root certificate in it.
+func installWineRootCertificate() {
+	var prefix string
+	huh.NewInput().
+		Title("Wine prefix (e.g., ~/.steam/steam/steamapps/compatdata/1240440/pfx); close the game first").
+		Value(&prefix).
+		WithTheme(theme.ThemeMITM()).
+		Run()
+
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" {
+		return
 	}
+
+	if strings.HasPrefix(prefix, "~/") {
+		home, _ := sysutilities.GetHomeDirectory()
+		prefix = filepath.Join(home, prefix[2:])
+	}
+
+	title := fmt.Sprintf("✅ The root certificate is now trusted in %s.", prefix)
+	if mitmErr := sysutilities.InstallRootCertificateInWinePrefix(certificate.GetCERPath(), prefix); mitmErr != nil {
+		title = fmt.Sprintf("❌ %s", mitmErr.String())
+	}
+
+	huh.NewConfirm().
+		Title(title).
+		Affirmative("Sounds good!").
+		Negative("").
+		WithTheme(theme.ThemeMITM()).
+		Run()
+}
+
+func showRootCertificateResult(mitmErr *errors.MITMError) {
+	title := "✅ A new root certificate has been generated; install it on every device using this proxy."
+	if mitmErr != nil {
+		title = fmt.Sprintf("❌ %s", mitmErr.String())
+	} else if current, mitmErr := certificate.Read(); mitmErr == nil {
+		title = fmt.Sprintf("%s\n\nFingerprint (SHA-256): %s\nExpires on: %s", title, certificate.Fingerprint(current), current.NotAfter.Format(time.DateOnly))
+	}
+
+	huh.NewConfirm().
+		Title(title).
+		Affirmative("Sounds good!").
+		Negative("").
+		WithTheme(theme.ThemeMITM()).
+		Run()
 }
@@ -18,8 +18,11 @@ import (
 	"fmt"
 	"infinite-mitm/configs"
 	credits "infinite-mitm/internal/application/ui/prompt/welcome/components/credits"
+	onboarding "infinite-mitm/internal/application/ui/prompt/welcome/components/onboarding"
 	tools "infinite-mitm/internal/application/ui/prompt/welcome/components/tools"
+	"infinite-mitm/pkg/certificate"
 	"infinite-mitm/pkg/errors"
+	"infinite-mitm/pkg/sysutilities"
 	"infinite-mitm/pkg/theme"
 
 	"github.com/charmbracelet/huh"
@@ -31,6 +34,7 @@ const (
 	// Welcome
 	Start PromptOption = iota
 	InstallRootCertificate
+	SetUpOtherDevices
 	Tools
 	Credits
 	Exit
@@ -40,6 +44,7 @@ var optionToString = map[PromptOption]string{
 	// Welcome
 	Start:                  "→ Start Proxy Server",
 	InstallRootCertificate: "→ Install Root Certificate",
+	SetUpOtherDevices:      "→ Set Up Other Devices",
 	Tools:                  "→ Show Tools",
 	Credits:                "→ Show Credits",
 	Exit:                   "→ Quit",
@@ -65,6 +70,7 @@ func Run(rootCertificateInstalled bool) (string, *errors.MITMError) {
 
 	options = append(
 		options,
+		huh.NewOption(SetUpOtherDevices.String(), SetUpOtherDevices.String()),
 		huh.NewOption(Tools.String(), Tools.String()),
 		huh.NewOption(Credits.String(), Credits.String()),
 		huh.NewOption(Exit.String(), Exit.String()),
@@ -72,6 +78,7 @@ func Run(rootCertificateInstalled bool) (string, *errors.MITMError) {
 
 	err := huh.NewSelect[string]().
 		Title(fmt.Sprintf("%s - %s", configs.GetConfig().Name, configs.GetConfig().Version)).
+		Description(onboarding.Description()).
 		Options(options...).
 		Value(&selected).
 		WithTheme(theme.ThemeMITM()).
@@ -82,7 +89,13 @@ func Run(rootCertificateInstalled bool) (string, *errors.MITMError) {
 	}
 
 	if Tools.Is(selected) {
-		tools.Run()
+		if tools.Run() {
+			rootCertificateInstalled = isRootCertificateInstalled()
+		}
+
+		return Run(rootCertificateInstalled)
+	} else if SetUpOtherDevices.Is(selected) {
+		onboarding.Run()
 		return Run(rootCertificateInstalled)
 	} else if Credits.Is(selected) {
 		credits.Run()
@@ -91,3 +104,13 @@ func Run(rootCertificateInstalled bool) (string, *errors.MITMError) {
 
 	return selected, nil
 }
+
+func isRootCertificateInstalled() bool {
+	rootCertificate, mitmErr := certificate.Read()
+	if mitmErr != nil {
+		return false
+	}
+
+	_, mitmErr = sysutilities.CheckForRootCertificate(rootCertificate)
+	return mitmErr == nil
+}
@@ -21,7 +21,6 @@ import (
 	"runtime"
 	"sync"
 
-	"infinite-mitm/configs"
 	mitmApplication "infinite-mitm/internal/application"
 	embedFS "infinite-mitm/internal/application/embed"
 	eventsService "infinite-mitm/internal/application/services/events"
@@ -29,7 +28,9 @@ import (
 	killService "infinite-mitm/internal/application/services/signal/kill"
 	networkUI "infinite-mitm/internal/application/ui/network"
 	welcomePromptUI "infinite-mitm/internal/application/ui/prompt/welcome"
+	"infinite-mitm/pkg/certificate"
 	"infinite-mitm/pkg/errors"
+	"infinite-mitm/pkg/mitm"
 	"infinite-mitm/pkg/proxy"
 	"infinite-mitm/pkg/spinner"
 	"infinite-mitm/pkg/sysutilities"
@@ -67,10 +68,14 @@ func Start(f *embed.FS, omitInit bool) *errors.MITMError {
 	}
 
 	if welcomePromptUI.Start.Is(option) {
+		if mitmErr := mitmApplication.ReviewCommands(); mitmErr != nil {
+			mitmErr.Log()
+		}
+
 		var wg sync.WaitGroup
 		var stopChan = make(chan struct{})
 
-		wg.Add(3)
+		wg.Add(4)
 
 		go func() {
 			defer wg.Done()
@@ -84,6 +89,11 @@ func Start(f *embed.FS, omitInit bool) *errors.MITMError {
 			mitmService.WatchClientMITMConfig(stopChan)
 		}()
 
+		go func() {
+			defer wg.Done()
+			mitmService.ReportStats(stopChan)
+		}()
+
 		go func() {
 			defer wg.Done()
 			once.Do(func() {
@@ -92,12 +102,32 @@ func Start(f *embed.FS, omitInit bool) *errors.MITMError {
 				})
 
 				event.On(eventsService.RestartServer, event.ListenerFunc(func(e event.Event) error {
-					restartServer(f, &wg)
+					restartServer(&wg)
+					return nil
+				}))
+
+				event.On(eventsService.ToggleChaos, event.ListenerFunc(func(e event.Event) error {
+					mitmService.ToggleChaos()
+					return nil
+				}))
+
+				event.On(eventsService.ReportRuleHits, event.ListenerFunc(func(e event.Event) error {
+					mitmService.ReportRuleHits()
+					return nil
+				}))
+
+				event.On(eventsService.ResetRuleHits, event.ListenerFunc(func(e event.Event) error {
+					mitmService.ResetRuleHits()
+					return nil
+				}))
+
+				event.On(eventsService.ResetScenarios, event.ListenerFunc(func(e event.Event) error {
+					mitmService.ResetScenarios()
 					return nil
 				}))
 			})
 
-			startServer(f)
+			startServer()
 		}()
 
 		wg.Wait()
@@ -105,13 +135,16 @@ func Start(f *embed.FS, omitInit bool) *errors.MITMError {
 	}
 
 	if welcomePromptUI.InstallRootCertificate.Is(option) {
-		if runtime.GOOS == "windows" {
-			sysutilities.InstallRootCertificate(f, fmt.Sprintf("%s.cer", configs.GetConfig().Proxy.Certificate.Name))
+		if runtime.GOOS == "windows" || runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
+			if mitmErr := sysutilities.InstallRootCertificate(certificate.GetCERPath()); mitmErr != nil {
+				return mitmErr
+			}
+
 			return Start(f, false)
 		}
 
-		spinner.Run("Attempting to open your browser...")
-		sysutilities.OpenBrowser(configs.GetConfig().Repository + "/blob/main/cert/" + fmt.Sprintf("%s.pem", configs.GetConfig().Proxy.Certificate.Name))
+		spinner.Run("Attempting to open the root certificate directory...")
+		sysutilities.OpenBrowser(certificate.GetDirPath())
 	} else if welcomePromptUI.Exit.Is(option) {
 		if mitmErr := proxy.ToggleProxy("off"); mitmErr != nil {
 			mitmErr.Log()
@@ -122,24 +155,43 @@ func Start(f *embed.FS, omitInit bool) *errors.MITMError {
 	return nil
 }
 
-func startServer(f *embed.FS) {
-	enableProxy()
+// Repair restores the system proxy settings left by a session that did not exit cleanly, even if it still appears to be running.
+func Repair() *errors.MITMError {
+	repaired, mitmErr := proxy.Repair(true)
+	if mitmErr != nil {
+		return mitmErr
+	}
+
+	if repaired {
+		fmt.Println("✅ The system proxy settings have been restored.")
+	} else {
+		fmt.Println("✅ Nothing to repair; the system proxy settings have not been changed.")
+	}
 
-	s, mitmErr := mitmService.CreateServer(f)
+	return nil
+}
+
+// startServer only points the system proxy at the server once it was created, and restores it when the server cannot listen,
+// so the system is never left proxied to a dead port.
+func startServer() {
+	s, mitmErr := mitmService.CreateServer()
 	if mitmErr != nil {
 		event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": mitmErr.String()})
 		return
 	}
 
 	server = s
+	enableProxy()
+
 	if err := server.ListenAndServe(); err != nil {
 		if err != http.ErrServerClosed {
+			disableProxy()
 			event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": err.Error()})
 		}
 	}
 }
 
-func restartServer(f *embed.FS, wg *sync.WaitGroup) {
+func restartServer(wg *sync.WaitGroup) {
 	restartMutex.Lock()
 	defer restartMutex.Unlock()
 
@@ -148,7 +200,7 @@ func restartServer(f *embed.FS, wg *sync.WaitGroup) {
 
 	go func() {
 		defer wg.Done()
-		startServer(f)
+		startServer()
 	}()
 }
 
@@ -165,6 +217,12 @@ func stopServer() {
 }
 
 func enableProxy() {
+	if content, mitmErr := mitm.ReadClientMITMConfig(); mitmErr == nil {
+		if err := proxy.SetMode(content.Options.SystemProxy); err != nil {
+			event.MustFire(eventsService.ProxyStatusMessage, event.M{"details": errors.Create(errors.ErrYAMLReadException, err.Error()).String()})
+		}
+	}
+
 	if mitmErr := proxy.ToggleProxy("on"); mitmErr != nil {
 		mitmErr.Log()
 	}
@@ -19,11 +19,11 @@ import (
 	MITM "infinite-mitm/internal"
 	embedFS "infinite-mitm/internal/application/embed"
 	"infinite-mitm/pkg/errors"
+	"os"
 )
 
 //go:generate goversioninfo -icon=assets/resources/windows/icon_256x256.ico
 //go:embed assets/resources/shared/*
-//go:embed cert/*
 var f embed.FS
 
 func init() {
@@ -31,6 +31,16 @@ func init() {
 }
 
 func main() {
+	// e.g., InfiniteMITM.exe repair
+	if len(os.Args) > 1 && os.Args[1] == "repair" {
+		if mitmErr := MITM.Repair(); mitmErr != nil {
+			mitmErr.Log()
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	if mitmErr := MITM.Start(&f, false); mitmErr != nil {
 		if mitmErr.Unwrap() != errors.ErrPromptException {
 			mitmErr.Log()
@@ -0,0 +1,64 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certificate
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+)
+
+// LegacyFingerprint is the SHA-256 fingerprint of the "InfiniteMITMRootCA" root CA shared by the versions which did not
+// generate one per installation; its private key ships with them, so anyone can sign certificates it trusts.
+const LegacyFingerprint = "86:4B:5A:BF:3C:9B:54:2E:F1:65:E5:EA:D6:ED:E5:6D:3C:58:BC:1C:75:95:BF:42:2F:00:38:A6:51:A5:B5:8A"
+
+const legacyPEM = `-----BEGIN CERTIFICATE-----
+MIIDxTCCAq2gAwIBAgIUPJ1qqBRMQ6idmnPfSmjnGPFS9jEwDQYJKoZIhvcNAQEL
+BQAwcjELMAkGA1UEBhMCVVMxDjAMBgNVBAgMBVN0YXRlMQ0wCwYDVQQHDARDaXR5
+MRUwEwYDVQQKDAxPcmdhbml6YXRpb24xEDAOBgNVBAsMB09yZ1VuaXQxGzAZBgNV
+BAMMEkluZmluaXRlTUlUTVJvb3RDQTAeFw0yNDA2MDMxNjEwMDhaFw0zNDA2MDEx
+NjEwMDhaMHIxCzAJBgNVBAYTAlVTMQ4wDAYDVQQIDAVTdGF0ZTENMAsGA1UEBwwE
+Q2l0eTEVMBMGA1UECgwMT3JnYW5pemF0aW9uMRAwDgYDVQQLDAdPcmdVbml0MRsw
+GQYDVQQDDBJJbmZpbml0ZU1JVE1Sb290Q0EwggEiMA0GCSqGSIb3DQEBAQUAA4IB
+DwAwggEKAoIBAQCm4YzHOIUozmdOEZClvOaPJfGRk7Q9Ecn5F6MC44v31VLVno4G
+hqactPpz3xEyp+lyutBtQDKZMPIwN9uTr0cucG3Mxg9h+rmg2FpH5YzVuJcrUojE
+/jUEMtX+S/BJt8PzeNTiPynWwMd2WEqIrci+LmBNbIy0f8cJHrtBZP+fotjM/0yv
+lxzku9hcfaOz3a7QjyUzonYOfqH1o3KlRM0G2MWfVzbV/Qfdm2OLF2WQcZKyrCAd
+Rm1By7+YTZuwMRk5s+w6s55/g0dd8asj0fCoUZXzX7nReguYCd37sFTRGMjuqdaT
+/50mA4Cck3Xhbh4oLG2Ap/8FB91qew3012ZxAgMBAAGjUzBRMB0GA1UdDgQWBBTK
+JGQysD0zj9HdChDN0UhTF4CeBTAfBgNVHSMEGDAWgBTKJGQysD0zj9HdChDN0UhT
+F4CeBTAPBgNVHRMBAf8EBTADAQH/MA0GCSqGSIb3DQEBCwUAA4IBAQA3N7UtPi89
+uID97+i+LzvCp3KyLVijZtOY2/bHnPTa+ceCwV5BltcgLCaBLGaw3LUVMF2R09Ab
+3i0ijaMjEktL5aFO5XgmaItVmgFosFn6oe8hzW3ejtcbSX/TIsX+glTwP+kWh9l1
+XwSfSkmdherGxR/dG3QGTb8Y6xpCQ0wAq0D8sqswLAQPDAdOBnAp/JGUAw/+RSXP
+DkBArobsoHFyBoids3WN0AZcw6oBBv1INEC41sfVsJuZdzuhQpbuHI/JjO7FfVVR
+wq4zRgdjfEDWsYLxGSt6IeNMknrgJAQxoHI3+pzLbEmseMrQXbYmhEhFfc9ETMOa
+37rOo380su6P
+-----END CERTIFICATE-----
+`
+
+// Legacy returns the shared root CA, to remove it from the trust stores where it is still installed.
+func Legacy() *x509.Certificate {
+	block, _ := pem.Decode([]byte(legacyPEM))
+	if block == nil {
+		return nil
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil || Fingerprint(cert) != LegacyFingerprint {
+		return nil
+	}
+
+	return cert
+}
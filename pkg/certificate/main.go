@@ -0,0 +1,230 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certificate
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"infinite-mitm/configs"
+	"infinite-mitm/pkg/errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	validity      = 3 * 365 * 24 * time.Hour
+	ExpiryWarning = 30 * 24 * time.Hour
+)
+
+var (
+	name    = configs.GetConfig().Proxy.Certificate.Name
+	dirPath = filepath.Join(configs.GetConfig().Extra.ProjectDir, "cert")
+	mutex   sync.Mutex
+)
+
+// GetDirPath returns the directory holding the root CA generated for this installation (e.g., ~/InfiniteMITM/cert).
+func GetDirPath() string {
+	return dirPath
+}
+
+// GetPEMPath returns the PEM encoded root CA, suitable for macOS, Linux and most devices.
+func GetPEMPath() string {
+	return filepath.Join(dirPath, name + ".pem")
+}
+
+// GetCERPath returns the DER encoded root CA, as expected by the Windows certificate store.
+func GetCERPath() string {
+	return filepath.Join(dirPath, name + ".cer")
+}
+
+func getKeyPath() string {
+	return filepath.Join(dirPath, name + ".key")
+}
+
+// Ensure generates the root CA on first run, or when its files are missing or expired; the expired root CA it replaced
+// is returned, so it can be removed from the trust stores.
+func Ensure() (*x509.Certificate, *errors.MITMError) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	cert, mitmErr := read()
+	if mitmErr == nil && time.Now().Before(cert.NotAfter) {
+		if _, err := os.Stat(getKeyPath()); err == nil {
+			return nil, nil
+		}
+	}
+
+	if mitmErr := generate(); mitmErr != nil {
+		return nil, mitmErr
+	}
+
+	if cert != nil && !time.Now().Before(cert.NotAfter) {
+		return cert, nil
+	}
+
+	return nil, nil
+}
+
+// Generate replaces the current root CA with a brand new one; every device that trusted the previous one must install it again.
+func Generate() *errors.MITMError {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return generate()
+}
+
+// Load returns the root CA key pair used to sign the intercepted hosts certificates.
+func Load() (tls.Certificate, *errors.MITMError) {
+	if _, mitmErr := Ensure(); mitmErr != nil {
+		return tls.Certificate{}, mitmErr
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	cert, err := tls.LoadX509KeyPair(GetPEMPath(), getKeyPath())
+	if err != nil {
+		return tls.Certificate{}, errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	return cert, nil
+}
+
+// Read returns the current root CA, without its private key.
+func Read() (*x509.Certificate, *errors.MITMError) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return read()
+}
+
+// Fingerprint returns the SHA-256 fingerprint of cert (e.g., "AB:CD:...").
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return formatFingerprint(sum[:])
+}
+
+func read() (*x509.Certificate, *errors.MITMError) {
+	data, err := os.ReadFile(GetPEMPath())
+	if err != nil {
+		return nil, errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.Create(errors.ErrProxyCertificateException, fmt.Sprintf("invalid %s", GetPEMPath()))
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	return cert, nil
+}
+
+func generate() *errors.MITMError {
+	if err := os.MkdirAll(dirPath, 0700); err != nil {
+		return errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	// MkdirAll keeps the permissions of an existing directory
+	if err := os.Chmod(dirPath, 0700); err != nil {
+		return errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:         name,
+			Organization:       []string{configs.GetConfig().Name},
+			OrganizationalUnit: []string{fmt.Sprintf("Generated on %s", now.Format(time.DateOnly))},
+		},
+		NotBefore:             now.Add(-24 * time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	keyData := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	certData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	if err := writeFile(getKeyPath(), keyData, 0600); err != nil {
+		return errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	if err := writeFile(GetPEMPath(), certData, 0644); err != nil {
+		return errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	if err := writeFile(GetCERPath(), der, 0644); err != nil {
+		return errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	return nil
+}
+
+// writeFile replaces target atomically so a crash never leaves a truncated file behind.
+func writeFile(target string, data []byte, perm os.FileMode) error {
+	temp := target + ".tmp"
+	if err := os.WriteFile(temp, data, perm); err != nil {
+		return err
+	}
+
+	// WriteFile keeps the permissions of an existing file
+	if err := os.Chmod(temp, perm); err != nil {
+		os.Remove(temp)
+		return err
+	}
+
+	return os.Rename(temp, target)
+}
+
+func formatFingerprint(sum []byte) string {
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package domains
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type YAMLBlocklist struct {
+	Presets []string `yaml:"presets,omitempty"`
+	Rules   []YAMLBlocklistRule `yaml:"rules,omitempty"`
+}
+
+type YAMLBlocklistRule struct {
+	Host       string `yaml:"host"`
+	Path       string `yaml:"path,omitempty"`
+	Methods    []string `yaml:"methods,omitempty"`
+	StatusCode int `yaml:"code,omitempty"`
+	Drop       bool `yaml:"drop,omitempty"`
+}
+
+// BlocklistPresets are the shipped "blocklist" rules, enabled by name in the "presets" of the mitm.yaml.
+var BlocklistPresets = map[string][]YAMLBlocklistRule{
+	// Windows and Xbox diagnostic data
+	"telemetry": {
+		{Host: "*.events.data.microsoft.com"},
+		{Host: "vortex.data.microsoft.com"},
+		{Host: "*.vortex-win.data.microsoft.com"},
+		{Host: "watson.telemetry.microsoft.com"},
+	},
+	// PlayFab player and title events
+	"analytics": {
+		{Host: "*.playfabapi.com", Path: "/Event/WriteEvents"},
+		{Host: "*.playfabapi.com", Path: "/Event/WriteTelemetryEvents"},
+		{Host: "*.playfabapi.com", Path: "/Client/WritePlayerEvent"},
+		{Host: "*.playfabapi.com", Path: "/Client/WriteTitleEvent"},
+	},
+}
+
+// GetBlocklistRules returns the rules of the enabled presets, followed by the "rules" of the blocklist.
+func GetBlocklistRules(blocklist YAMLBlocklist) ([]YAMLBlocklistRule, error) {
+	var rules []YAMLBlocklistRule
+
+	for _, preset := range blocklist.Presets {
+		presetRules, ok := BlocklistPresets[strings.ToLower(strings.TrimSpace(preset))]
+		if !ok {
+			return nil, fmt.Errorf("unknown blocklist preset: %s (expected %s)", preset, strings.Join(getBlocklistPresetNames(), ", "))
+		}
+
+		rules = append(rules, presetRules...)
+	}
+
+	for _, rule := range blocklist.Rules {
+		host := strings.ToLower(strings.TrimSpace(rule.Host))
+		if host == "" || host == "*" || strings.ContainsAny(strings.TrimPrefix(host, "*."), "/:*? ") {
+			return nil, fmt.Errorf("invalid blocklist host: %s (expected a hostname or *.example.com)", rule.Host)
+		}
+
+		rule.Host = host
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func getBlocklistPresetNames() []string {
+	names := make([]string, 0, len(BlocklistPresets))
+	for name := range BlocklistPresets {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
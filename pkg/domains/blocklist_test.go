@@ -0,0 +1,61 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package domains
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetBlocklistRules(t *testing.T) {
+	tests := []struct {
+		name      string
+		blocklist YAMLBlocklist
+		want      []YAMLBlocklistRule
+		wantErr   bool
+	}{
+		{"empty", YAMLBlocklist{}, nil, false},
+		{"preset", YAMLBlocklist{Presets: []string{" Telemetry "}}, BlocklistPresets["telemetry"], false},
+		{
+			"presets before rules",
+			YAMLBlocklist{
+				Presets: []string{"analytics"},
+				Rules:   []YAMLBlocklistRule{{Host: " Ads.Example.com ", Path: "/track", Methods: []string{"POST"}, StatusCode: 204}},
+			},
+			append(append([]YAMLBlocklistRule{}, BlocklistPresets["analytics"]...), YAMLBlocklistRule{Host: "ads.example.com", Path: "/track", Methods: []string{"POST"}, StatusCode: 204}),
+			false,
+		},
+		{"wildcard host", YAMLBlocklist{Rules: []YAMLBlocklistRule{{Host: "*.example.com", Drop: true}}}, []YAMLBlocklistRule{{Host: "*.example.com", Drop: true}}, false},
+		{"unknown preset", YAMLBlocklist{Presets: []string{"ads"}}, nil, true},
+		{"empty host", YAMLBlocklist{Rules: []YAMLBlocklistRule{{Host: " "}}}, nil, true},
+		{"every host", YAMLBlocklist{Rules: []YAMLBlocklistRule{{Host: "*"}}}, nil, true},
+		{"host with a port", YAMLBlocklist{Rules: []YAMLBlocklistRule{{Host: "example.com:443"}}}, nil, true},
+		{"host with a path", YAMLBlocklist{Rules: []YAMLBlocklistRule{{Host: "example.com/ads"}}}, nil, true},
+		{"nested wildcard", YAMLBlocklist{Rules: []YAMLBlocklistRule{{Host: "ads.*.example.com"}}}, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := GetBlocklistRules(test.blocklist)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("GetBlocklistRules() error = %v, wantErr %t", err, test.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("GetBlocklistRules() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package domains
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type YAMLCustomDomain struct {
+	Hostname  string `yaml:"hostname"`
+	Intercept bool `yaml:"intercept"`
+}
+
+// CustomDomain is a hostname declared in the "custom_domains" of the mitm.yaml; its alias is usable
+// as a "domains" key and as a pattern parameter (e.g., "xbl-profile" → :xbl-profile-svc).
+type CustomDomain struct {
+	Alias     string
+	Hostname  DomainType
+	Intercept bool
+}
+
+var aliasRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// keys of the YAMLDomains fields, which cannot be used as aliases
+var reservedAliases = []string{"root", "blobs", "authoring", "discovery", "stats", "settings", "gamecms", "economy", "lobby", "skill"}
+
+// ParseCustomDomains validates the "custom_domains" entries, sorted by alias.
+func ParseCustomDomains(entries map[string]YAMLCustomDomain) ([]CustomDomain, error) {
+	customDomains := make([]CustomDomain, 0, len(entries))
+
+	for alias, entry := range entries {
+		if !aliasRegexp.MatchString(alias) {
+			return nil, fmt.Errorf("invalid custom domain alias: %s (expected lowercase letters, digits and dashes)", alias)
+		}
+
+		for _, reserved := range reservedAliases {
+			if alias == reserved {
+				return nil, fmt.Errorf("invalid custom domain alias: %s is already used by a built-in domain", alias)
+			}
+		}
+
+		hostname := strings.ToLower(strings.TrimSpace(entry.Hostname))
+		if hostname == "" || strings.ContainsAny(hostname, "/:*? ") {
+			return nil, fmt.Errorf("invalid hostname for custom domain %s: %s", alias, entry.Hostname)
+		}
+
+		customDomains = append(customDomains, CustomDomain{Alias: alias, Hostname: hostname, Intercept: entry.Intercept})
+	}
+
+	sort.Slice(customDomains, func(i, j int) bool {
+		return customDomains[i].Alias < customDomains[j].Alias
+	})
+
+	return customDomains, nil
+}
+
+// GetCustomParameters returns the :alias-svc pattern parameters of the custom domains, and their base URL.
+func GetCustomParameters(customDomains []CustomDomain) map[string]string {
+	parameters := make(map[string]string, len(customDomains))
+	for _, customDomain := range customDomains {
+		parameters[fmt.Sprintf(":%s-svc", customDomain.Alias)] = DomainToBaseURL(customDomain.Hostname)
+	}
+
+	return parameters
+}
+
+// GetInterceptedHostnames returns the hostnames of the custom domains to intercept.
+func GetInterceptedHostnames(customDomains []CustomDomain) []string {
+	var hostnames []string
+	for _, customDomain := range customDomains {
+		if customDomain.Intercept {
+			hostnames = append(hostnames, customDomain.Hostname)
+		}
+	}
+
+	return hostnames
+}
@@ -44,34 +44,125 @@ type YAMLDomains struct {
 	Economy   []YAMLDomainNode `yaml:"economy,omitempty"`
 	Lobby     []YAMLDomainNode `yaml:"lobby,omitempty"`
 	Skill     []YAMLDomainNode `yaml:"skill,omitempty"`
+	Custom    map[string][]YAMLDomainNode `yaml:",inline"`
 }
 
 type YAMLDomainNode struct {
 	Path     string   `yaml:"path"`
 	Methods  []string `yaml:"methods,omitempty"`
+	Clients  []string `yaml:"clients,omitempty"`
+	Script   string `yaml:"script,omitempty"`
+	Chaos    YAMLDomainChaos `yaml:"chaos,omitempty"`
+	Block    YAMLDomainBlock `yaml:"block,omitempty"`
+	Expire   YAMLDomainExpire `yaml:"expire,omitempty"`
+	Scenario YAMLDomainScenario `yaml:"scenario,omitempty"`
+	Extract  []YAMLDomainExtract `yaml:"extract,omitempty"`
 	Request  YAMLDomainRequestNode `yaml:"request,omitempty"`
 	Response YAMLDomainResponseNode `yaml:"response,omitempty"`
 }
 
 type YAMLDomainRequestNode struct {
 	Body    string `yaml:"body,omitempty"`
+	BodyTemplate string `yaml:"body_template,omitempty"`
 	Headers map[string]string `yaml:"headers,omitempty"`
+	Rewrite YAMLDomainRewrite `yaml:"rewrite,omitempty"`
 	Before  YAMLDomainTrafficCommands `yaml:"before,omitempty"`
 }
 
+// YAMLDomainRewrite sends the request to another host, path or query (empty values are kept); with "redirect",
+// the client is redirected to the new URL instead.
+type YAMLDomainRewrite struct {
+	Host     string `yaml:"host,omitempty"`
+	Path     string `yaml:"path,omitempty"`
+	Query    string `yaml:"query,omitempty"`
+	Redirect int `yaml:"redirect,omitempty"`
+}
+
 type YAMLDomainTrafficCommands struct {
-	Commands []YAMLDomainTrafficRunCommand `yaml:"commands,omitempty"`
+	Commands   []YAMLDomainTrafficRunCommand `yaml:"commands,omitempty"`
+	Sequential bool `yaml:"sequential,omitempty"`
 }
 
 type YAMLDomainTrafficRunCommand struct {
-	Run []string `yaml:"run"`
+	Run     []string `yaml:"run"`
+	Stdin   string `yaml:"stdin,omitempty"`
+	Stdout  string `yaml:"stdout,omitempty"`
+	Env     map[string]string `yaml:"env,omitempty"`
+	Timeout string `yaml:"timeout,omitempty"`
 }
 
 type YAMLDomainResponseNode struct {
 	Body       string `yaml:"body,omitempty"`
+	BodyTemplate string `yaml:"body_template,omitempty"`
 	Headers    map[string]string `yaml:"headers,omitempty"`
 	StatusCode int `yaml:"code,omitempty"`
 	Before     YAMLDomainTrafficCommands `yaml:"before,omitempty"`
+	After      YAMLDomainTrafficCommands `yaml:"after,omitempty"`
+	Variants   []YAMLDomainResponseVariant `yaml:"variants,omitempty"`
+	Order      string `yaml:"order,omitempty"`
+	Loop       bool `yaml:"loop,omitempty"`
+}
+
+// YAMLDomainResponseVariant replaces the body, body template and status code of the response, and adds its headers, for the hits it is picked.
+type YAMLDomainResponseVariant struct {
+	Weight       int `yaml:"weight,omitempty"`
+	Body         string `yaml:"body,omitempty"`
+	BodyTemplate string `yaml:"body_template,omitempty"`
+	Headers      map[string]string `yaml:"headers,omitempty"`
+	StatusCode   int `yaml:"code,omitempty"`
+}
+
+// YAMLDomainExpire disables the rule after a number of hits, or once the window following its first hit is over.
+type YAMLDomainExpire struct {
+	Hits   int64 `yaml:"hits,omitempty"`
+	Window string `yaml:"window,omitempty"`
+}
+
+// YAMLDomainBlock answers the request from the proxy: "block: true" returns an empty 204, otherwise the "code" or a dropped connection.
+type YAMLDomainBlock struct {
+	Enabled    bool `yaml:"-"`
+	StatusCode int `yaml:"code,omitempty"`
+	Drop       bool `yaml:"drop,omitempty"`
+}
+
+type YAMLDomainChaos struct {
+	Delay     string `yaml:"delay,omitempty"`
+	DelayMax  string `yaml:"delay_max,omitempty"`
+	Bandwidth string `yaml:"bandwidth,omitempty"`
+	Fault     YAMLDomainChaosFault `yaml:"fault,omitempty"`
+}
+
+type YAMLDomainChaosFault struct {
+	Type        string `yaml:"type,omitempty"`
+	Probability *float64 `yaml:"probability,omitempty"`
+	StatusCode  int `yaml:"code,omitempty"`
+	Timeout     string `yaml:"timeout,omitempty"`
+	Truncate    string `yaml:"truncate,omitempty"`
+}
+
+// YAMLScenario is a state machine of the "scenarios" of the mitm.yaml, moved from one state to another by the rules.
+type YAMLScenario struct {
+	Initial   string `yaml:"initial"`
+	States    []string `yaml:"states,omitempty"`
+	Variables map[string]string `yaml:"variables,omitempty"`
+}
+
+// YAMLDomainScenario only lets the rule match in the "when" state of the scenario; once the rule fires,
+// the scenario moves to the "then" state and its variables are "set".
+type YAMLDomainScenario struct {
+	Name string `yaml:"name,omitempty"`
+	When string `yaml:"when,omitempty"`
+	Then string `yaml:"then,omitempty"`
+	Set  map[string]string `yaml:"set,omitempty"`
+}
+
+// YAMLDomainExtract saves a value of the response in the session variables, usable as ${name} by the next rules.
+type YAMLDomainExtract struct {
+	Name    string `yaml:"name"`
+	JSON    string `yaml:"json,omitempty"`
+	Regex   string `yaml:"regex,omitempty"`
+	Header  string `yaml:"header,omitempty"`
+	Capture int `yaml:"capture,omitempty"`
 }
 
 type YAMLContentDomainPair struct {
@@ -81,6 +172,15 @@ type YAMLContentDomainPair struct {
 
 type YAMLContentDomainPairs []YAMLContentDomainPair
 
+// CommandBody is the "stdin" and "stdout" value of the commands piping the traffic body.
+const CommandBody = "body"
+
+// order of the response variants
+const (
+	VariantsSequence = "sequence"
+	VariantsRandom   = "random"
+)
+
 const (
 	Root      DomainType  = ".svc.halowaypoint.com"
 	Blobs     DomainType  = "blobs-infiniteugc.svc.halowaypoint.com"
@@ -116,7 +216,8 @@ var SmartCachableHostnames = []string{
 	DomainToHostname(Skill),
 }
 
-func GetYAMLContentDomainPairs(yamlDomains YAMLDomains) YAMLContentDomainPairs {
+// GetYAMLContentDomainPairs returns the rules of each built-in domain, followed by the rules of the intercepted custom domains.
+func GetYAMLContentDomainPairs(yamlDomains YAMLDomains, customDomains []CustomDomain) YAMLContentDomainPairs {
 	pairs := YAMLContentDomainPairs{
 		{Content: yamlDomains.Root, Domain: HaloWaypointSVCDomains.Root},
 		{Content: yamlDomains.Blobs, Domain: HaloWaypointSVCDomains.Blobs},
@@ -130,9 +231,29 @@ func GetYAMLContentDomainPairs(yamlDomains YAMLDomains) YAMLContentDomainPairs {
 		{Content: yamlDomains.Skill, Domain: HaloWaypointSVCDomains.Skill},
 	}
 
+	for _, customDomain := range customDomains {
+		if customDomain.Intercept {
+			pairs = append(pairs, YAMLContentDomainPair{Content: yamlDomains.Custom[customDomain.Alias], Domain: customDomain.Hostname})
+		}
+	}
+
 	return pairs
 }
 
+func (b *YAMLDomainBlock) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&b.Enabled); err == nil {
+		return nil
+	}
+
+	type plain YAMLDomainBlock
+	if err := unmarshal((*plain)(b)); err != nil {
+		return err
+	}
+
+	b.Enabled = true
+	return nil
+}
+
 func DomainToHostname(domain DomainType) string {
 	return strings.Split(domain, ":")[0]
 }
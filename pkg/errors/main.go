@@ -48,6 +48,7 @@ var (
 	ErrMITMYamlSchemaOutdatedException = errors.New("mitm yaml schema outdated exception")
 	ErrIntegrityYamlSchemaOutdatedException = errors.New("integrity yaml schema outdated exception")
 	ErrPingFailedException = errors.New("ping failed exception")
+	ErrScriptException = errors.New("script exception")
 )
 
 func (e *MITMError) Error() string {
@@ -20,6 +20,7 @@ import (
 	"infinite-mitm/pkg/domains"
 	"infinite-mitm/pkg/errors"
 	"infinite-mitm/pkg/smartcache"
+	"infinite-mitm/pkg/utilities"
 	"os"
 	"path/filepath"
 
@@ -29,14 +30,77 @@ import (
 type TrafficDisplay string
 type TrafficOptions struct {
 	TrafficDisplay TrafficDisplay
+	CaptureLimit   int64
+}
+
+type YAMLServerOptions struct {
+	Bind      string `yaml:"bind,omitempty"`
+	Allowlist []string `yaml:"allowlist,omitempty"`
+	Auth      YAMLServerAuthOptions `yaml:"auth,omitempty"`
+	Clients   map[string]string `yaml:"clients,omitempty"`
+}
+
+type YAMLServerAuthOptions struct {
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+}
+
+type YAMLUpstreamOptions struct {
+	URL      string `yaml:"url,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	NoProxy  []string `yaml:"no_proxy,omitempty"`
+}
+
+type YAMLTransportOptions struct {
+	DialTimeout           string `yaml:"dial_timeout,omitempty"`
+	TLSHandshakeTimeout   string `yaml:"tls_handshake_timeout,omitempty"`
+	ResponseHeaderTimeout string `yaml:"response_header_timeout,omitempty"`
+	IdleConnTimeout       string `yaml:"idle_conn_timeout,omitempty"`
+	MaxIdleConns          int `yaml:"max_idle_conns,omitempty"`
+	MaxIdleConnsPerHost   int `yaml:"max_idle_conns_per_host,omitempty"`
+	MaxConnsPerHost       int `yaml:"max_conns_per_host,omitempty"`
+	Resolve               map[string]string `yaml:"resolve,omitempty"`
+}
+
+type YAMLUpstreamOverride struct {
+	Target      string `yaml:"target"`
+	StripPrefix string `yaml:"strip_prefix,omitempty"`
+	CA          string `yaml:"ca,omitempty"`
+	Insecure    bool `yaml:"insecure,omitempty"`
+}
+
+type YAMLScriptingOptions struct {
+	Timeout   string `yaml:"timeout,omitempty"`
+	MaxSteps  uint64 `yaml:"max_steps,omitempty"`
+	MaxMemory string `yaml:"max_memory,omitempty"`
+	MaxBody   string `yaml:"max_body,omitempty"`
+	AllowRead []string `yaml:"allow_read,omitempty"`
+}
+
+type YAMLCommandsOptions struct {
+	Enabled *bool `yaml:"enabled,omitempty"`
 }
 
 type YAMLOptions struct {
 	SmartCache     smartcache.SmartCacheYAMLOptions `yaml:"smart_cache"`
 	TrafficDisplay TrafficDisplay `yaml:"traffic_display"`
+	CaptureLimit   string `yaml:"capture_limit,omitempty"`
+	InterceptHosts []string `yaml:"intercept_hosts,omitempty"`
+	SystemProxy    string `yaml:"system_proxy,omitempty"`
+	Server         YAMLServerOptions `yaml:"server,omitempty"`
+	Upstream       YAMLUpstreamOptions `yaml:"upstream,omitempty"`
+	Transport      YAMLTransportOptions `yaml:"transport,omitempty"`
+	UpstreamOverride map[string]YAMLUpstreamOverride `yaml:"upstream_override,omitempty"`
+	Variables      map[string]string `yaml:"variables,omitempty"`
+	Scripting      YAMLScriptingOptions `yaml:"scripting,omitempty"`
+	Commands       YAMLCommandsOptions `yaml:"commands,omitempty"`
 }
 
 type YAML struct {
+	CustomDomains map[string]domains.YAMLCustomDomain `yaml:"custom_domains,omitempty"`
+	Blocklist domains.YAMLBlocklist `yaml:"blocklist,omitempty"`
+	Scenarios map[string]domains.YAMLScenario `yaml:"scenarios,omitempty"`
 	Domains domains.YAMLDomains `yaml:"domains"`
 	Options YAMLOptions `yaml:"options"`
 	Version int `yaml:"version"`
@@ -47,6 +111,11 @@ const (
 	MITMVersion = 1
 )
 
+const (
+	DefaultCaptureLimit = 100 * 1024
+	DefaultBind = "127.0.0.1"
+)
+
 const (
 	TrafficAll        TrafficDisplay = "all"
 	TrafficOverrides  TrafficDisplay = "overrides"
@@ -73,6 +142,24 @@ func ReadClientMITMConfig() (YAML, *errors.MITMError) {
 	return content, nil
 }
 
+func ParseCaptureLimit(value string) int64 {
+	if value == "" {
+		return DefaultCaptureLimit
+	}
+
+	limit, err := utilities.ParseByteSize(value)
+	if err != nil {
+		return DefaultCaptureLimit
+	}
+
+	return limit
+}
+
+// IsEnabled reports whether the rule commands may run; they are enabled unless "enabled: false" is set.
+func (o YAMLCommandsOptions) IsEnabled() bool {
+	return o.Enabled == nil || *o.Enabled
+}
+
 func WriteMITMFile(content YAML) {
 	buffer, err := yaml.Marshal(content)
 	if err != nil {
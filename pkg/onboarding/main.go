@@ -0,0 +1,218 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package onboarding
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"infinite-mitm/configs"
+	"infinite-mitm/pkg/certificate"
+	"infinite-mitm/pkg/sysutilities"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hostname is answered by the proxy itself (like mitm.it) so other devices can download the root certificate.
+const Hostname = "infinite.mitm"
+
+type download struct {
+	extension   string
+	contentType string
+}
+
+type pageData struct {
+	Name        string
+	Version     string
+	Certificate string
+	Fingerprint string
+	Expires     string
+	ProxyHost   string
+	ProxyPort   int
+}
+
+var downloads = map[string]download{
+	"/cert.pem": {extension: "pem", contentType: "application/x-pem-file"},
+	"/cert.cer": {extension: "cer", contentType: "application/pkix-cert"},
+	"/cert.der": {extension: "der", contentType: "application/x-x509-ca-cert"},
+}
+
+var page = template.Must(template.New("onboarding").Parse(pageTemplate))
+
+// GetURL returns the onboarding URL for devices already using the proxy.
+func GetURL() string {
+	return "http://" + Hostname
+}
+
+// GetLANURL returns the onboarding URL for devices on the same network, reachable without any proxy configured.
+func GetLANURL() string {
+	return fmt.Sprintf("http://%s:%d", sysutilities.GetLocalIP(), configs.GetConfig().Proxy.Port)
+}
+
+func IsOnboardingRequest(req *http.Request) bool {
+	return strings.EqualFold(req.URL.Hostname(), Hostname)
+}
+
+// Response renders the onboarding page, or one of the root certificate downloads, for req.
+func Response(req *http.Request) *http.Response {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return respond(req, http.StatusMethodNotAllowed, "text/plain; charset=utf-8", []byte(http.StatusText(http.StatusMethodNotAllowed)))
+	}
+
+	target, isDownload := downloads[req.URL.Path]
+	if !isDownload && req.URL.Path != "/" {
+		return respond(req, http.StatusNotFound, "text/plain; charset=utf-8", []byte(http.StatusText(http.StatusNotFound)))
+	}
+
+	cert, mitmErr := certificate.Read()
+	if mitmErr != nil {
+		return respond(req, http.StatusInternalServerError, "text/plain; charset=utf-8", []byte(mitmErr.String()))
+	}
+
+	if isDownload {
+		var body []byte
+		var err error
+
+		if target.extension == "pem" {
+			body, err = os.ReadFile(certificate.GetPEMPath())
+		} else {
+			body, err = os.ReadFile(certificate.GetCERPath())
+		}
+
+		if err != nil {
+			return respond(req, http.StatusInternalServerError, "text/plain; charset=utf-8", []byte(err.Error()))
+		}
+
+		resp := respond(req, http.StatusOK, target.contentType, body)
+		resp.Header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", configs.GetConfig().Proxy.Certificate.Name, target.extension))
+		return resp
+	}
+
+	var buffer bytes.Buffer
+	page.Execute(&buffer, pageData{
+		Name:        configs.GetConfig().Name,
+		Version:     configs.GetConfig().Version,
+		Certificate: configs.GetConfig().Proxy.Certificate.Name,
+		Fingerprint: certificate.Fingerprint(cert),
+		Expires:     cert.NotAfter.Format(time.DateOnly),
+		ProxyHost:   sysutilities.GetLocalIP(),
+		ProxyPort:   configs.GetConfig().Proxy.Port,
+	})
+
+	return respond(req, http.StatusOK, "text/html; charset=utf-8", buffer.Bytes())
+}
+
+// Handler serves the onboarding page to direct (non-proxy) requests, e.g., http://192.168.1.20:1337.
+func Handler(w http.ResponseWriter, req *http.Request) {
+	resp := Response(req)
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	if req.Method != http.MethodHead {
+		io.Copy(w, resp.Body)
+	}
+}
+
+func respond(req *http.Request, status int, contentType string, body []byte) *http.Response {
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Set("Cache-Control", "no-store")
+
+	return &http.Response{
+		Request:       req,
+		StatusCode:    status,
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
+
+const pageTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Name}} - Root Certificate</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Roboto, sans-serif; max-width: 760px; margin: 2em auto; padding: 0 1em; line-height: 1.5; color: #1f2328; }
+h1 { margin-bottom: 0; }
+code { background: #f2f2f2; padding: 0.1em 0.3em; border-radius: 4px; word-break: break-all; }
+.downloads a { display: inline-block; margin: 0.3em 0.5em 0.3em 0; padding: 0.5em 1em; background: #1f6feb; color: #fff; border-radius: 6px; text-decoration: none; }
+.warning { background: #fff8c5; padding: 0.75em 1em; border-radius: 6px; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<p>{{.Version}}</p>
+
+<h2>1. Download the root certificate</h2>
+<p class="downloads">
+<a href="/cert.pem">{{.Certificate}}.pem</a>
+<a href="/cert.cer">{{.Certificate}}.cer</a>
+<a href="/cert.der">{{.Certificate}}.der</a>
+</p>
+<p>SHA-256 fingerprint: <code>{{.Fingerprint}}</code><br>Expires on: <code>{{.Expires}}</code></p>
+<p class="warning">Only install this certificate if the fingerprint above matches the one displayed by {{.Name}} on the host computer. Anyone holding its private key can read the traffic of this device.</p>
+
+<h2>2. Install and trust it</h2>
+<h3>Windows</h3>
+<ol>
+<li>Open <b>{{.Certificate}}.cer</b> and click <b>Install Certificate</b>.</li>
+<li>Install it for the <b>Current User</b>, then select <b>Place all certificates in the following store</b>.</li>
+<li>Select <b>Trusted Root Certification Authorities</b>, click <b>Next</b> and <b>Finish</b>.</li>
+</ol>
+<h3>macOS</h3>
+<ol>
+<li>Open <b>{{.Certificate}}.pem</b> to add it to the <b>login</b> keychain in <b>Keychain Access</b>.</li>
+<li>Double-click on <b>{{.Certificate}}</b>, expand <b>Trust</b> and set <b>When using this certificate</b> to <b>Always Trust</b>.</li>
+</ol>
+<h3>Linux</h3>
+<ol>
+<li>Debian/Ubuntu: <code>sudo cp {{.Certificate}}.pem /usr/local/share/ca-certificates/{{.Certificate}}.crt &amp;&amp; sudo update-ca-certificates</code></li>
+<li>Fedora/Arch: <code>sudo trust anchor {{.Certificate}}.pem</code></li>
+</ol>
+<h3>iOS / iPadOS</h3>
+<ol>
+<li>Open this page in <b>Safari</b>, download <b>{{.Certificate}}.pem</b> and allow the profile download.</li>
+<li>Go to <b>Settings → General → VPN &amp; Device Management</b> and install the profile.</li>
+<li>Go to <b>Settings → General → About → Certificate Trust Settings</b> and enable full trust for <b>{{.Certificate}}</b>.</li>
+</ol>
+<h3>Android</h3>
+<ol>
+<li>Download <b>{{.Certificate}}.der</b>.</li>
+<li>Go to <b>Settings → Security → Encryption &amp; credentials → Install a certificate → CA certificate</b> and select it.</li>
+</ol>
+<h3>Xbox / other consoles</h3>
+<p>Consoles do not allow installing custom root certificates: hosts decrypted by the proxy will fail their TLS checks, while tunnelled hosts keep working.</p>
+
+<h2>3. Use the proxy</h2>
+<p>Set the HTTP proxy of this device to <code>{{.ProxyHost}}</code>, port <code>{{.ProxyPort}}</code>.</p>
+<p>Or, to only send the Halo Waypoint traffic through the proxy, use the automatic proxy configuration URL <code>http://{{.ProxyHost}}:{{.ProxyPort}}/proxy.pac</code>.</p>
+</body>
+</html>
+`
@@ -0,0 +1,108 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pac
+
+import (
+	"fmt"
+	"infinite-mitm/configs"
+	"infinite-mitm/pkg/domains"
+	"infinite-mitm/pkg/onboarding"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Path is served by the proxy itself to direct requests (e.g., http://127.0.0.1:1337/proxy.pac).
+const Path = "/proxy.pac"
+
+var (
+	customHosts []string
+	mutex       sync.RWMutex
+)
+
+// GetURL returns the PAC URL the system proxy settings point at.
+func GetURL() string {
+	return fmt.Sprintf("http://%s%s", net.JoinHostPort(configs.GetConfig().Proxy.Host, strconv.Itoa(configs.GetConfig().Proxy.Port)), Path)
+}
+
+// SetHosts replaces the hosts routed through the proxy on top of the Halo Waypoint ones, using the
+// "intercept_hosts" syntax of the mitm.yaml ("*", "*.example.com" or "example.com").
+func SetHosts(patterns []string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	customHosts = append([]string{}, patterns...)
+}
+
+func getHosts() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return append([]string{"*" + domains.HaloWaypointSVCDomains.Root, onboarding.Hostname}, customHosts...)
+}
+
+// Script returns a PAC script sending the intercepted hosts to proxyAddr and everything else DIRECT.
+func Script(proxyAddr string) string {
+	var conditions []string
+	for _, pattern := range getHosts() {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+
+		if pattern == "*" {
+			conditions = []string{"true"}
+			break
+		}
+
+		if strings.HasPrefix(pattern, "*.") {
+			conditions = append(conditions, fmt.Sprintf("dnsDomainIs(host, %s)", strconv.Quote(pattern[1:])))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("host == %s", strconv.Quote(pattern)))
+		}
+	}
+
+	return fmt.Sprintf(
+		"function FindProxyForURL(url, host) {\n\thost = host.toLowerCase();\n\tif (%s) {\n\t\treturn \"PROXY %s\";\n\t}\n\n\treturn \"DIRECT\";\n}\n",
+		strings.Join(conditions, " ||\n\t\t"),
+		proxyAddr,
+	)
+}
+
+// Handler serves the PAC script; the proxy address is taken from the requested host so devices
+// fetching it through the network (e.g., http://192.168.1.20:1337/proxy.pac) get a reachable one.
+func Handler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	proxyAddr := req.Host
+	if proxyAddr == "" {
+		proxyAddr = net.JoinHostPort(configs.GetConfig().Proxy.Host, strconv.Itoa(configs.GetConfig().Proxy.Port))
+	}
+
+	script := Script(proxyAddr)
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	w.Header().Set("Content-Length", strconv.Itoa(len(script)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	if req.Method != http.MethodHead {
+		w.Write([]byte(script))
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pac
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScript(t *testing.T) {
+	tests := []struct {
+		name       string
+		hosts      []string
+		conditions string
+	}{
+		{
+			"default hosts",
+			nil,
+			`dnsDomainIs(host, ".svc.halowaypoint.com") ||` + "\n\t\t" + `host == "infinite.mitm"`,
+		},
+		{
+			"custom hosts",
+			[]string{" *.Example.com ", "", "api.example.org"},
+			`dnsDomainIs(host, ".svc.halowaypoint.com") ||` + "\n\t\t" + `host == "infinite.mitm" ||` + "\n\t\t" + `dnsDomainIs(host, ".example.com") ||` + "\n\t\t" + `host == "api.example.org"`,
+		},
+		{
+			"every host",
+			[]string{"api.example.org", "*"},
+			"true",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			SetHosts(test.hosts)
+			defer SetHosts(nil)
+
+			script := Script("127.0.0.1:1337")
+			want := "function FindProxyForURL(url, host) {\n\thost = host.toLowerCase();\n\tif (" + test.conditions + ") {\n\t\treturn \"PROXY 127.0.0.1:1337\";\n\t}\n\n\treturn \"DIRECT\";\n}\n"
+
+			if script != want {
+				t.Errorf("Script() = %q, want %q", script, want)
+			}
+		})
+	}
+}
+
+func TestScriptQuotesHosts(t *testing.T) {
+	SetHosts([]string{`evil") || alert("x`})
+	defer SetHosts(nil)
+
+	if script := Script("127.0.0.1:1337"); !strings.Contains(script, `host == "evil\") || alert(\"x"`) {
+		t.Errorf("Script() did not quote the host: %s", script)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 type Patterns struct {
@@ -38,6 +39,10 @@ var (
 	mitmDirsKeys   []string
 	replacer       *strings.Replacer
 	matchRefRegexp = regexp.MustCompile(`\$\d+`)
+
+	customParameters     map[string]string
+	customParametersKeys []string
+	customMutex          sync.RWMutex
 )
 
 var MatchParameters = Patterns{
@@ -168,7 +173,44 @@ func Create(domain domains.DomainType, path string) *regexp.Regexp {
 	return re
 }
 
+// LiteralPrefix returns the part of a rule path which is matched literally, before any parameter or regex.
+func LiteralPrefix(path string) string {
+	if path == "" || !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	if index := strings.IndexAny(path, ":*$()"); index >= 0 {
+		return path[:index]
+	}
+
+	return path
+}
+
+// SetCustomParameters replaces the parameters registered at runtime (e.g., the :alias-svc of the custom domains).
+func SetCustomParameters(parameters map[string]string) {
+	keys := make([]string, 0, len(parameters))
+	for k := range parameters {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return len(keys[i]) > len(keys[j])
+	})
+
+	customMutex.Lock()
+	defer customMutex.Unlock()
+
+	customParameters = parameters
+	customParametersKeys = keys
+}
+
 func ReplaceParameters(value string) string {
+	customMutex.RLock()
+	for _, k := range customParametersKeys {
+		value = strings.ReplaceAll(value, k, customParameters[k])
+	}
+	customMutex.RUnlock()
+
 	for _, k := range mitmDirsKeys {
 		value = strings.ReplaceAll(value, k, mitmDirs[k])
 	}
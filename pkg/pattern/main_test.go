@@ -0,0 +1,40 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pattern
+
+import "testing"
+
+func TestLiteralPrefix(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"hipc/spartan", "/hipc/spartan"},
+		{"/hipc/spartan", "/hipc/spartan"},
+		{"/hipc/:guid/settings", "/hipc/"},
+		{"/ugcstorage/map/*", "/ugcstorage/map/"},
+		{"/stats/(matches|players)", "/stats/"},
+		{"/oban/flight-configurations$", "/oban/flight-configurations"},
+		{":xuid", "/"},
+	}
+
+	for _, test := range tests {
+		if got := LiteralPrefix(test.path); got != test.want {
+			t.Errorf("LiteralPrefix(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
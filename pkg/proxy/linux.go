@@ -0,0 +1,214 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package proxy
+
+import (
+	"fmt"
+	"infinite-mitm/configs"
+	"infinite-mitm/pkg/pac"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	linuxDesktopGNOME = "gnome"
+	linuxDesktopKDE   = "kde"
+)
+
+var linuxSnippetsDir = filepath.Join(configs.GetConfig().Extra.ProjectDir, "linux")
+
+// GetLinuxSnippetsDir returns the directory holding the generated proxy.env and proxychains.conf (e.g., ~/InfiniteMITM/linux).
+func GetLinuxSnippetsDir() string {
+	return linuxSnippetsDir
+}
+
+func enableProxyLinux() error {
+	// applications ignoring the desktop settings (e.g., Proton through a Steam launch option) rely on these
+	if err := writeLinuxSnippets(); err != nil {
+		return err
+	}
+
+	switch detectLinuxDesktop() {
+	case linuxDesktopKDE:
+		return runCommands([][]string{
+			kwriteconfig("Proxy Config Script", pac.GetURL()),
+			kwriteconfig("ProxyType", "2"),
+		}, notifyKDE)
+	case linuxDesktopGNOME:
+		return runCommands([][]string{
+			{"gsettings", "set", "org.gnome.system.proxy", "autoconfig-url", pac.GetURL()},
+			{"gsettings", "set", "org.gnome.system.proxy", "mode", "auto"},
+		}, nil)
+	}
+
+	return nil
+}
+
+func disableProxyLinux() error {
+	switch detectLinuxDesktop() {
+	case linuxDesktopKDE:
+		return runCommands([][]string{kwriteconfig("ProxyType", "0")}, notifyKDE)
+	case linuxDesktopGNOME:
+		return runCommands([][]string{{"gsettings", "set", "org.gnome.system.proxy", "mode", "none"}}, nil)
+	}
+
+	return nil
+}
+
+func readSettingsLinux() (Settings, error) {
+	desktop := detectLinuxDesktop()
+	settings := Settings{"desktop": desktop}
+
+	switch desktop {
+	case linuxDesktopKDE:
+		for _, key := range []string{"ProxyType", "Proxy Config Script"} {
+			out, err := exec.Command(getKReadConfig(), "--file", "kioslaverc", "--group", "Proxy Settings", "--key", key).Output()
+			if err != nil {
+				return nil, err
+			}
+
+			settings[key] = strings.TrimSpace(string(out))
+		}
+	case linuxDesktopGNOME:
+		for _, key := range []string{"mode", "autoconfig-url"} {
+			out, err := exec.Command("gsettings", "get", "org.gnome.system.proxy", key).Output()
+			if err != nil {
+				return nil, err
+			}
+
+			settings[key] = strings.Trim(strings.TrimSpace(string(out)), "'")
+		}
+	}
+
+	return settings, nil
+}
+
+func writeSettingsLinux(settings Settings) error {
+	switch settings["desktop"] {
+	case linuxDesktopKDE:
+		proxyType := settings["ProxyType"]
+		if proxyType == "" {
+			proxyType = "0"
+		}
+
+		return runCommands([][]string{
+			kwriteconfig("Proxy Config Script", settings["Proxy Config Script"]),
+			kwriteconfig("ProxyType", proxyType),
+		}, notifyKDE)
+	case linuxDesktopGNOME:
+		mode := settings["mode"]
+		if mode == "" {
+			mode = "none"
+		}
+
+		return runCommands([][]string{
+			{"gsettings", "set", "org.gnome.system.proxy", "autoconfig-url", settings["autoconfig-url"]},
+			{"gsettings", "set", "org.gnome.system.proxy", "mode", mode},
+		}, nil)
+	}
+
+	// no supported desktop was found, nothing has been changed
+	return nil
+}
+
+// detectLinuxDesktop returns the desktop whose proxy settings can be toggled; GNOME based desktops
+// (e.g., Cinnamon, Budgie) share the gsettings schema, other desktops are left untouched.
+func detectLinuxDesktop() string {
+	desktop := strings.ToLower(os.Getenv("XDG_CURRENT_DESKTOP"))
+	if strings.Contains(desktop, linuxDesktopKDE) && getKWriteConfig() != "" {
+		return linuxDesktopKDE
+	}
+
+	// gsettings may be installed without the GNOME schemas (e.g., headless systems)
+	if err := exec.Command("gsettings", "list-keys", "org.gnome.system.proxy").Run(); err == nil {
+		return linuxDesktopGNOME
+	}
+
+	return ""
+}
+
+func getKWriteConfig() string {
+	return lookPathFirst("kwriteconfig6", "kwriteconfig5")
+}
+
+func getKReadConfig() string {
+	return lookPathFirst("kreadconfig6", "kreadconfig5")
+}
+
+func lookPathFirst(names ...string) string {
+	for _, name := range names {
+		if _, err := exec.LookPath(name); err == nil {
+			return name
+		}
+	}
+
+	return ""
+}
+
+func kwriteconfig(key string, value string) []string {
+	return []string{getKWriteConfig(), "--file", "kioslaverc", "--group", "Proxy Settings", "--key", key, value}
+}
+
+// notifyKDE asks running KDE applications to reload kioslaverc; it fails silently outside of a KDE session.
+func notifyKDE() {
+	exec.Command("dbus-send", "--type=signal", "/KIO/Scheduler", "org.kde.KIO.Scheduler.reparseSlaveConfiguration", "string:").Run()
+}
+
+func runCommands(commands [][]string, after func()) error {
+	for _, args := range commands {
+		if err := exec.Command(args[0], args[1:]...).Run(); err != nil {
+			return err
+		}
+	}
+
+	if after != nil {
+		after()
+	}
+
+	return nil
+}
+
+func writeLinuxSnippets() error {
+	if err := os.MkdirAll(linuxSnippetsDir, 0755); err != nil {
+		return err
+	}
+
+	proxyURL := fmt.Sprintf("http://%s:%s", proxyHost, proxyPort)
+	env := fmt.Sprintf(
+		"# source %s\n"+
+			"export http_proxy=%s\nexport https_proxy=%s\n"+
+			"export HTTP_PROXY=%s\nexport HTTPS_PROXY=%s\n"+
+			"export no_proxy=localhost,127.0.0.1,::1\nexport NO_PROXY=localhost,127.0.0.1,::1\n",
+		filepath.Join(linuxSnippetsDir, "proxy.env"), proxyURL, proxyURL, proxyURL, proxyURL,
+	)
+
+	proxychains := fmt.Sprintf(
+		"# proxychains4 -f %s %%command%%\n"+
+			"strict_chain\nproxy_dns\ntcp_read_time_out 15000\ntcp_connect_time_out 8000\n"+
+			"localnet 127.0.0.0/255.0.0.0\n\n[ProxyList]\nhttp %s %s\n",
+		filepath.Join(linuxSnippetsDir, "proxychains.conf"), proxyHost, proxyPort,
+	)
+
+	if err := os.WriteFile(filepath.Join(linuxSnippetsDir, "proxy.env"), []byte(env), 0644); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(linuxSnippetsDir, "proxychains.conf"), []byte(proxychains), 0644)
+}
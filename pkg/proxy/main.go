@@ -15,33 +15,86 @@
 package proxy
 
 import (
+	"bytes"
+	"encoding/hex"
 	"fmt"
 	"infinite-mitm/configs"
 	"infinite-mitm/pkg/errors"
+	"infinite-mitm/pkg/pac"
 	"os/exec"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 var proxyHost = configs.GetConfig().Proxy.Host
 var proxyPort = strconv.Itoa(configs.GetConfig().Proxy.Port)
 
 const darwinFallbackService = "Wi-Fi"
+const windowsInternetSettingsKey = `HKCU\Software\Microsoft\Windows\CurrentVersion\Internet Settings`
+// the WinHTTP proxy set by "netsh winhttp", stored as binary
+const windowsWinHTTPSettingsKey = `HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\Internet Settings\Connections`
+
+const (
+	// ModeGlobal points the WinHTTP proxy of Windows at the proxy, as used by the game; the hosts without rules are tunnelled untouched
+	ModeGlobal = "global"
+	// ModePAC points the system at the PAC script, which only sends the intercepted hosts through the proxy
+	ModePAC = "pac"
+)
 
 var darwinActiveService = darwinFallbackService
+var mode = defaultMode()
+var mutex sync.Mutex
 
+// SetMode selects how the system is pointed at the proxy on Windows ("system_proxy" option; see defaultMode when empty);
+// macOS and Linux always use the PAC script.
+func SetMode(value string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	switch value {
+	case "":
+		mode = defaultMode()
+	case ModeGlobal, ModePAC:
+		mode = value
+	default:
+		mode = defaultMode()
+		return fmt.Errorf("invalid system_proxy: %s (expected %s or %s)", value, ModeGlobal, ModePAC)
+	}
+
+	return nil
+}
+
+// defaultMode is the PAC script, except on Windows: the game only reads the WinHTTP proxy, which cannot point at a PAC
+// script, so it would not go through the proxy anymore.
+func defaultMode() string {
+	if runtime.GOOS == "windows" {
+		return ModeGlobal
+	}
+
+	return ModePAC
+}
+
+// ToggleProxy points the system proxy settings at the proxy ("on"), or restores the ones recorded beforehand ("off").
 func ToggleProxy(command string) *errors.MITMError {
 	if command != "on" && command != "off" {
 		return errors.Create(errors.ErrProxyToggleInvalidCommand, "invalid command")
 	}
 
+	mutex.Lock()
+	defer mutex.Unlock()
+
 	if command == "on" {
+		if err := recordSettings(); err != nil {
+			return errors.Create(errors.ErrProxy, err.Error())
+		}
+
 		if err := enableProxy(); err != nil {
 			return errors.Create(errors.ErrProxy, err.Error())
 		}
 	} else {
-		if err := disableProxy(); err != nil {
+		if err := restoreRecordedSettings(); err != nil {
 			return errors.Create(errors.ErrProxy, err.Error())
 		}
 	}
@@ -55,6 +108,8 @@ func enableProxy() error {
 		return enableProxyWindows()
 	case "darwin":
 		return enableProxyDarwin()
+	case "linux":
+		return enableProxyLinux()
 	}
 
 	return nil
@@ -66,12 +121,21 @@ func disableProxy() error {
 		return disableProxyWindows()
 	case "darwin":
 		return disableProxyDarwin()
+	case "linux":
+		return disableProxyLinux()
 	}
 
 	return nil
 }
 
+// enableProxyWindows sets the WinHTTP proxy, used by the game, or with the "pac" mode points the Internet Settings
+// of the current user at the PAC script; the latter is only picked up by WinINet applications, and WinHTTP ones
+// which explicitly read them (WinHttpGetIEProxyConfigForCurrentUser).
 func enableProxyWindows() error {
+	if mode == ModePAC {
+		return exec.Command("reg", "add", windowsInternetSettingsKey, "/v", "AutoConfigURL", "/t", "REG_SZ", "/d", pac.GetURL(), "/f").Run()
+	}
+
 	proxyArg := fmt.Sprintf("proxy-server=\"http=%s:%s;https=%s:%s\"", proxyHost, proxyPort, proxyHost, proxyPort)
 	return exec.Command("netsh", "winhttp", "set", "proxy", proxyArg, "\"<-loopback>\"").Run()
 }
@@ -81,10 +145,8 @@ func enableProxyDarwin() error {
 	service := darwinActiveService
 
 	commands := [][]string{
-		{"networksetup", "-setwebproxy", service, proxyHost, proxyPort},
-		{"networksetup", "-setsecurewebproxy", service, proxyHost, proxyPort},
-		{"networksetup", "-setwebproxystate", service, "on"},
-		{"networksetup", "-setsecurewebproxystate", service, "on"},
+		{"networksetup", "-setautoproxyurl", service, pac.GetURL()},
+		{"networksetup", "-setautoproxystate", service, "on"},
 	}
 
 	for _, args := range commands {
@@ -96,18 +158,182 @@ func enableProxyDarwin() error {
 	return nil
 }
 
+// disableProxyWindows is used when no settings were recorded: the WinHTTP proxy and PAC script are only removed
+// when they point at this proxy, so the ones set by the user (e.g., a corporate proxy) are kept.
 func disableProxyWindows() error {
-	return exec.Command("netsh", "winhttp", "reset", "proxy").Run()
+	if winHTTPSettings, err := readWinHTTPSettings(); err == nil && isWinHTTPProxySet(winHTTPSettings) {
+		if err := exec.Command("netsh", "winhttp", "reset", "proxy").Run(); err != nil {
+			return err
+		}
+	}
+
+	settings, err := readSettingsWindows()
+	if err != nil || settings["AutoConfigURL"] != pac.GetURL() {
+		return err
+	}
+
+	err = exec.Command("reg", "delete", windowsInternetSettingsKey, "/v", "AutoConfigURL", "/f").Run()
+	if _, isExitError := err.(*exec.ExitError); isExitError {
+		// the value does not exist anymore
+		return nil
+	}
+
+	return err
 }
 
 func disableProxyDarwin() error {
 	service := darwinActiveService
 
 	commands := [][]string{
-		{"networksetup", "-setwebproxystate", service, "off"},
-		{"networksetup", "-setsecurewebproxystate", service, "off"},
+		{"networksetup", "-setautoproxystate", service, "off"},
+	}
+
+	for _, args := range commands {
+		if err := exec.Command(args[0], args[1:]...).Run(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readSettingsWindows() (Settings, error) {
+	winHTTPSettings, err := readWinHTTPSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := Settings{"WinHttpSettings": winHTTPSettings}
+
+	url, err := readRegistryValue(windowsInternetSettingsKey, "AutoConfigURL", "REG_SZ")
+	if err != nil {
+		return nil, err
+	}
+
+	if url != "" {
+		settings["AutoConfigURL"] = url
+	}
+
+	return settings, nil
+}
+
+// writeSettingsWindows puts back the recorded settings; the WinHTTP proxy is only written when it was changed since.
+func writeSettingsWindows(settings Settings) error {
+	current, err := readWinHTTPSettings()
+	if err != nil {
+		return err
+	}
+
+	if recorded, ok := settings["WinHttpSettings"]; ok && recorded != current {
+		if err := writeWinHTTPSettings(recorded); err != nil {
+			return err
+		}
+	} else if !ok && isWinHTTPProxySet(current) {
+		// recorded by a previous version, which did not record the WinHTTP proxy
+		if err := exec.Command("netsh", "winhttp", "reset", "proxy").Run(); err != nil {
+			return err
+		}
+	}
+
+	if url := settings["AutoConfigURL"]; url != "" {
+		return exec.Command("reg", "add", windowsInternetSettingsKey, "/v", "AutoConfigURL", "/t", "REG_SZ", "/d", url, "/f").Run()
+	}
+
+	err = exec.Command("reg", "delete", windowsInternetSettingsKey, "/v", "AutoConfigURL", "/f").Run()
+	if _, isExitError := err.(*exec.ExitError); isExitError {
+		return nil
+	}
+
+	return err
+}
+
+// readWinHTTPSettings returns the hex encoded WinHTTP proxy settings, or an empty string when they were never set.
+func readWinHTTPSettings() (string, error) {
+	return readRegistryValue(windowsWinHTTPSettingsKey, "WinHttpSettings", "REG_BINARY")
+}
+
+func writeWinHTTPSettings(value string) error {
+	if value == "" {
+		return exec.Command("netsh", "winhttp", "reset", "proxy").Run()
+	}
+
+	return exec.Command("reg", "add", windowsWinHTTPSettingsKey, "/v", "WinHttpSettings", "/t", "REG_BINARY", "/d", value, "/f").Run()
+}
+
+// isWinHTTPProxySet reports whether the WinHTTP proxy settings point at this proxy; the proxy server is stored as plain text.
+func isWinHTTPProxySet(value string) bool {
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Contains(data, []byte(proxyHost + ":" + proxyPort))
+}
+
+// readRegistryValue returns a registry value as printed by "reg query", or an empty string when it does not exist.
+func readRegistryValue(key string, name string, valueType string) (string, error) {
+	out, err := exec.Command("reg", "query", key, "/v", name).Output()
+	if _, isExitError := err.(*exec.ExitError); isExitError {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if _, value, found := strings.Cut(line, valueType); found && strings.Contains(line, name) {
+			return strings.TrimSpace(value), nil
+		}
+	}
+
+	return "", nil
+}
+
+func readSettingsDarwin() (Settings, error) {
+	service := detectDarwinActiveService()
+	settings := Settings{"service": service, "url": "", "enabled": "No"}
+
+	out, err := exec.Command("networksetup", "-getautoproxyurl", service).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "URL":
+			if value != "(null)" {
+				settings["url"] = value
+			}
+		case "Enabled":
+			settings["enabled"] = value
+		}
+	}
+
+	return settings, nil
+}
+
+func writeSettingsDarwin(settings Settings) error {
+	service := settings["service"]
+	if service == "" {
+		service = darwinActiveService
+	}
+
+	var commands [][]string
+	if settings["url"] != "" {
+		commands = append(commands, []string{"networksetup", "-setautoproxyurl", service, settings["url"]})
+	}
+
+	state := "off"
+	if settings["enabled"] == "Yes" {
+		state = "on"
 	}
 
+	commands = append(commands, []string{"networksetup", "-setautoproxystate", service, state})
 	for _, args := range commands {
 		if err := exec.Command(args[0], args[1:]...).Run(); err != nil {
 			return err
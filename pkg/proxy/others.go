@@ -0,0 +1,42 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package proxy
+
+import "fmt"
+
+var errLinuxOnly = fmt.Errorf("the Linux desktop proxy settings are only available on Linux")
+
+func GetLinuxSnippetsDir() string {
+	return ""
+}
+
+func enableProxyLinux() error {
+	return errLinuxOnly
+}
+
+func disableProxyLinux() error {
+	return errLinuxOnly
+}
+
+func readSettingsLinux() (Settings, error) {
+	return nil, errLinuxOnly
+}
+
+func writeSettingsLinux(settings Settings) error {
+	return errLinuxOnly
+}
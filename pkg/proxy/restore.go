@@ -0,0 +1,170 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"encoding/json"
+	"infinite-mitm/configs"
+	"infinite-mitm/pkg/errors"
+	"infinite-mitm/pkg/sysutilities"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Settings holds the system proxy settings found before enabling the proxy, as read on the current platform.
+type Settings map[string]string
+
+// sentinel is written before the system proxy is changed and removed once restored, so a session
+// killed before restoring it (e.g., crash, power loss) can be detected and repaired on the next start.
+type sentinel struct {
+	PID      int      `json:"pid"`
+	Platform string   `json:"platform"`
+	Settings Settings `json:"settings"`
+}
+
+const sentinelFilename = "proxy.lock"
+
+var sentinelPath = filepath.Join(configs.GetConfig().Extra.ProjectDir, sentinelFilename)
+
+// Repair restores the settings recorded by a previous session that did not exit cleanly and reports whether it did;
+// unless force is set, settings recorded by a process still running (e.g., another instance) are left untouched.
+func Repair(force bool) (bool, *errors.MITMError) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	recorded, err := readSentinel()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		// unreadable sentinel: at least point the system away from the proxy
+		os.Remove(sentinelPath)
+		if err := disableProxy(); err != nil {
+			return false, errors.Create(errors.ErrProxy, err.Error())
+		}
+
+		return true, nil
+	}
+
+	if !force && (recorded.PID == os.Getpid() || sysutilities.IsProcessRunning(recorded.PID)) {
+		return false, nil
+	}
+
+	if err := restoreSettings(recorded); err != nil {
+		return false, errors.Create(errors.ErrProxy, err.Error())
+	}
+
+	return true, nil
+}
+
+// recordSettings writes the sentinel before the system proxy is changed; settings already recorded by a previous
+// session are kept, as they are the last ones known to have been set by the user.
+func recordSettings() error {
+	recorded, err := readSentinel()
+	if err != nil {
+		settings, err := readSettings()
+		if err != nil {
+			return err
+		}
+
+		recorded = &sentinel{Platform: runtime.GOOS, Settings: settings}
+	}
+
+	recorded.PID = os.Getpid()
+	return writeSentinel(recorded)
+}
+
+// restoreRecordedSettings puts back the settings recorded by recordSettings, or simply disables the proxy when none were.
+func restoreRecordedSettings() error {
+	recorded, err := readSentinel()
+	if err != nil {
+		return disableProxy()
+	}
+
+	return restoreSettings(recorded)
+}
+
+func restoreSettings(recorded *sentinel) error {
+	if recorded.Platform != runtime.GOOS {
+		if err := disableProxy(); err != nil {
+			return err
+		}
+	} else if err := writeSettings(recorded.Settings); err != nil {
+		return err
+	}
+
+	return os.Remove(sentinelPath)
+}
+
+func readSettings() (Settings, error) {
+	switch runtime.GOOS {
+	case "windows":
+		return readSettingsWindows()
+	case "darwin":
+		return readSettingsDarwin()
+	case "linux":
+		return readSettingsLinux()
+	}
+
+	return Settings{}, nil
+}
+
+func writeSettings(settings Settings) error {
+	switch runtime.GOOS {
+	case "windows":
+		return writeSettingsWindows(settings)
+	case "darwin":
+		return writeSettingsDarwin(settings)
+	case "linux":
+		return writeSettingsLinux(settings)
+	}
+
+	return nil
+}
+
+func readSentinel() (*sentinel, error) {
+	data, err := os.ReadFile(sentinelPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var recorded sentinel
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		return nil, err
+	}
+
+	return &recorded, nil
+}
+
+func writeSentinel(recorded *sentinel) error {
+	data, err := json.MarshalIndent(recorded, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(sentinelPath), 0755); err != nil {
+		return err
+	}
+
+	// written atomically so a crash never leaves a truncated sentinel behind
+	temp := sentinelPath + ".tmp"
+	if err := os.WriteFile(temp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, sentinelPath)
+}
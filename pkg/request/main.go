@@ -48,9 +48,25 @@ var client = &http.Client{
 }
 
 func Send(method, url string, payload []byte, header http.Header) ([]byte, http.Header, *errors.MITMError) {
+	resp, mitmErr := Open(method, url, payload, header)
+	if mitmErr != nil {
+		return nil, http.Header{}, mitmErr
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, http.Header{}, errors.Create(errors.ErrHTTPBodyReadException, err.Error())
+	}
+
+	return body, resp.Header, nil
+}
+
+// Open sends the request and returns the response with its body left unread; callers must close it.
+func Open(method, url string, payload []byte, header http.Header) (*http.Response, *errors.MITMError) {
 	req, err := http.NewRequest(method, url, bytes.NewReader(payload))
 	if err != nil {
-		return nil, http.Header{}, errors.Create(errors.ErrHTTPRequestException, err.Error())
+		return nil, errors.Create(errors.ErrHTTPRequestException, err.Error())
 	}
 
 	for key, values := range header {
@@ -61,20 +77,15 @@ func Send(method, url string, payload []byte, header http.Header) ([]byte, http.
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, http.Header{}, errors.Create(errors.ErrHTTPRequestException, err.Error())
+		return nil, errors.Create(errors.ErrHTTPRequestException, err.Error())
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, http.Header{}, errors.Create(errors.ErrHTTPCodeError, fmt.Sprintf("status code: %d", resp.StatusCode))
+		resp.Body.Close()
+		return nil, errors.Create(errors.ErrHTTPCodeError, fmt.Sprintf("status code: %d", resp.StatusCode))
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, http.Header{}, errors.Create(errors.ErrHTTPBodyReadException, err.Error())
-	}
-
-	return body, resp.Header, nil
+	return resp, nil
 }
 
 func StripPort(u string) string {
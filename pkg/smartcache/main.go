@@ -37,15 +37,31 @@ import (
 type StrategyType string
 
 type SmartCache struct {
-	strategy StrategyType
-	duration time.Duration
-	items    map[string]*SmartCacheItem
+	strategy   StrategyType
+	duration   time.Duration
+	items      map[string]*SmartCacheItem
+	itemsMutex sync.RWMutex
+
+	flights      map[string]*SmartCacheFlight
+	flightsMutex sync.Mutex
+
+	prefetcher      *prefetcher
+	prefetcherMutex sync.Mutex
+}
+
+type SmartCacheFlight struct {
+	cache *SmartCache
+	key   string
+	done  chan struct{}
+	once  sync.Once
+	item  *SmartCacheItem
 }
 
 type SmartCacheYAMLOptions struct {
 	Enabled  bool `yaml:"enabled"`
 	Strategy StrategyType `yaml:"strategy"`
 	TTL      string `yaml:"ttl"`
+	Prefetch SmartCachePrefetchYAMLOptions `yaml:"prefetch,omitempty"`
 }
 
 type SmartCacheItem struct {
@@ -64,6 +80,7 @@ const (
 	version = 2
 	defaultDuration = 7 * 24 * time.Hour
 	lockStripeCount = 64
+	flightTimeout = 30 * time.Second
 )
 
 var (
@@ -91,6 +108,7 @@ func New(strategy StrategyType, ttl string) *SmartCache {
 		strategy: strategy,
 		duration: parseDuration(ttl),
 		items:    make(map[string]*SmartCacheItem),
+		flights:  make(map[string]*SmartCacheFlight),
 	}
 
 	return sc
@@ -140,7 +158,11 @@ func (s *SmartCache) Get(key string) *SmartCacheItem {
 		return item
 	}
 
-	if item, exists := s.items[key]; exists && !s.isExpired(item) {
+	s.itemsMutex.RLock()
+	item, exists := s.items[key]
+	s.itemsMutex.RUnlock()
+
+	if exists && !s.isExpired(item) {
 		since := time.Since(item.Created)
 		seconds := int(since.Seconds())
 
@@ -167,7 +189,9 @@ func (s *SmartCache) Write(key string, item *SmartCacheItem) {
 	item.Header.Del(request.CacheControlHeaderKey)
 
 	if s.strategy == Memory {
+		s.itemsMutex.Lock()
 		s.items[key] = item
+		s.itemsMutex.Unlock()
 		return
 	}
 
@@ -180,6 +204,49 @@ func (s *SmartCache) Write(key string, item *SmartCacheItem) {
 	}
 }
 
+// Join returns the ongoing upstream fetch for key, or registers a new one; the
+// second value is true when the caller is the one expected to perform the fetch.
+func (s *SmartCache) Join(key string) (*SmartCacheFlight, bool) {
+	s.flightsMutex.Lock()
+	defer s.flightsMutex.Unlock()
+
+	if flight, exists := s.flights[key]; exists {
+		return flight, false
+	}
+
+	flight := &SmartCacheFlight{
+		cache: s,
+		key:   key,
+		done:  make(chan struct{}),
+	}
+
+	s.flights[key] = flight
+	return flight, true
+}
+
+// Land shares the fetched item (nil when the response could not be cached) with all waiters.
+func (f *SmartCacheFlight) Land(item *SmartCacheItem) {
+	f.once.Do(func() {
+		f.cache.flightsMutex.Lock()
+		if f.cache.flights[f.key] == f {
+			delete(f.cache.flights, f.key)
+		}
+		f.cache.flightsMutex.Unlock()
+
+		f.item = item
+		close(f.done)
+	})
+}
+
+func (f *SmartCacheFlight) Wait() *SmartCacheItem {
+	select {
+	case <-f.done:
+		return f.item
+	case <-time.After(flightTimeout):
+		return nil
+	}
+}
+
 func (s *SmartCache) isExpired(item *SmartCacheItem) bool {
 	if item.Expires == (time.Time{}) {
 		return true
@@ -230,7 +297,7 @@ func IsRequestSmartCachable(req *http.Request) bool {
 	hostname := req.URL.Hostname()
 	path := req.URL.Path
 
-	if utilities.Contains(domains.SmartCachableHostnames, hostname) {
+	if IsHostnameSmartCachable(hostname) {
 		if hostname == domains.DomainToHostname(domains.Skill) {
 			return strings.HasSuffix(path, "/skill")
 		} else if hostname == domains.DomainToHostname(domains.HaloStats) {
@@ -246,3 +313,7 @@ func IsRequestSmartCachable(req *http.Request) bool {
 
 	return false
 }
+
+func IsHostnameSmartCachable(hostname string) bool {
+	return utilities.Contains(domains.SmartCachableHostnames, hostname)
+}
@@ -0,0 +1,263 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package smartcache
+
+import (
+	"encoding/json"
+	"infinite-mitm/pkg/domains"
+	"infinite-mitm/pkg/request"
+	"infinite-mitm/pkg/throttle"
+	"infinite-mitm/pkg/utilities"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+type SmartCachePrefetchYAMLOptions struct {
+	Enabled     bool `yaml:"enabled"`
+	Concurrency int `yaml:"concurrency,omitempty"`
+	Bandwidth   string `yaml:"bandwidth,omitempty"`
+}
+
+type prefetcher struct {
+	cache     *SmartCache
+	client    *http.Client
+	jobs      chan prefetchJob
+	done      chan struct{}
+	limiter   *throttle.Limiter
+	queued    map[string]bool
+	headers   map[string]http.Header
+	mutex     sync.Mutex
+}
+
+type prefetchJob struct {
+	target string
+	header http.Header
+}
+
+const defaultPrefetchConcurrency = 4
+// URLs found once the queue is full are not prefetched; the game requests them itself
+const prefetchQueueSize = 1024
+
+var prefetchManifestHostnames = []string{
+	domains.DomainToHostname(domains.Discovery),
+	domains.DomainToHostname(domains.Authoring),
+}
+
+// ConfigurePrefetch enables (or disables) background warming of assets referenced by UGC manifests; the assets are requested
+// through transport, so they follow the same route as the game requests (e.g., upstream proxy, upstream_override).
+func (s *SmartCache) ConfigurePrefetch(options SmartCachePrefetchYAMLOptions, transport http.RoundTripper) {
+	s.prefetcherMutex.Lock()
+	defer s.prefetcherMutex.Unlock()
+
+	if s.prefetcher != nil {
+		close(s.prefetcher.done)
+	}
+
+	if !options.Enabled {
+		s.prefetcher = nil
+		return
+	}
+
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultPrefetchConcurrency
+	}
+
+	var bandwidth int64
+	if options.Bandwidth != "" {
+		bandwidth, _ = utilities.ParseByteSize(options.Bandwidth)
+	}
+
+	s.prefetcher = &prefetcher{
+		cache:   s,
+		client:  &http.Client{Transport: transport},
+		jobs:    make(chan prefetchJob, prefetchQueueSize),
+		done:    make(chan struct{}),
+		limiter: throttle.NewLimiter(bandwidth),
+		queued:  make(map[string]bool),
+		headers: make(map[string]http.Header),
+	}
+
+	for i := 0; i < concurrency; i++ {
+		go s.prefetcher.work()
+	}
+}
+
+// Observe remembers the headers the game sends to each cachable host, so prefetched items share its cache keys.
+func (s *SmartCache) Observe(req *http.Request) {
+	p := s.getPrefetcher()
+	if p == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	p.headers[req.URL.Hostname()] = req.Header.Clone()
+	p.mutex.Unlock()
+}
+
+// Prefetch looks for blob URLs within a cached discovery/authoring JSON response and warms the cache with them.
+func (s *SmartCache) Prefetch(req *http.Request, header http.Header, body []byte) {
+	p := s.getPrefetcher()
+	if p == nil || !utilities.Contains(prefetchManifestHostnames, req.URL.Hostname()) {
+		return
+	}
+
+	if !strings.Contains(header.Get(request.ContentTypeHeaderKey), "json") {
+		return
+	}
+
+	var manifest interface{}
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return
+	}
+
+	for _, target := range extractBlobURLs(manifest) {
+		p.enqueue(target, req.Header)
+	}
+}
+
+func (s *SmartCache) getPrefetcher() *prefetcher {
+	s.prefetcherMutex.Lock()
+	defer s.prefetcherMutex.Unlock()
+	return s.prefetcher
+}
+
+func (p *prefetcher) enqueue(target string, fallbackHeader http.Header) {
+	parse, err := url.Parse(target)
+	if err != nil {
+		return
+	}
+
+	p.mutex.Lock()
+	if p.queued[target] {
+		p.mutex.Unlock()
+		return
+	}
+
+	header, exists := p.headers[parse.Hostname()]
+	if !exists {
+		header = fallbackHeader.Clone()
+		header.Del("Accept")
+	}
+
+	p.queued[target] = true
+	p.mutex.Unlock()
+
+	select {
+	case p.jobs <- prefetchJob{target: target, header: header}:
+	default:
+		p.dequeue(target)
+	}
+}
+
+func (p *prefetcher) dequeue(target string) {
+	p.mutex.Lock()
+	delete(p.queued, target)
+	p.mutex.Unlock()
+}
+
+// work fetches the queued URLs until the prefetcher is replaced or disabled.
+func (p *prefetcher) work() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case job := <-p.jobs:
+			p.fetch(job.target, job.header)
+			p.dequeue(job.target)
+		}
+	}
+}
+
+func (p *prefetcher) fetch(target string, header http.Header) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return
+	}
+
+	req.Header = header.Clone()
+	if !IsRequestSmartCachable(req) {
+		return
+	}
+
+	key := p.cache.CreateKey(request.StripPort(target), header.Get("Accept"), header.Get("Accept-Language"))
+	if p.cache.Get(key) != nil {
+		return
+	}
+
+	flight, leader := p.cache.Join(key)
+	if !leader {
+		return
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		flight.Land(nil)
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(p.limiter.Reader(resp.Body))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		flight.Land(nil)
+		return
+	}
+
+	item := &SmartCacheItem{
+		Body: body,
+		Header: resp.Header.Clone(),
+	}
+
+	p.cache.Write(key, item)
+	flight.Land(item)
+}
+
+func extractBlobURLs(node interface{}) []string {
+	var urls []string
+	blobsURL := domains.DomainToBaseURL(domains.Blobs)
+
+	switch value := node.(type) {
+	case map[string]interface{}:
+		// e.g., "Files": { "Prefix": "https://blobs-infiniteugc.svc.halowaypoint.com/ugcstorage/...", "FileRelativePaths": [...] }
+		prefix, hasPrefix := value["Prefix"].(string)
+		paths, hasPaths := value["FileRelativePaths"].([]interface{})
+		if hasPrefix && hasPaths && strings.HasPrefix(prefix, blobsURL) {
+			for _, path := range paths {
+				if str, ok := path.(string); ok {
+					urls = append(urls, prefix + strings.TrimPrefix(str, "/"))
+				}
+			}
+		}
+
+		for key, child := range value {
+			if key != "Prefix" {
+				urls = append(urls, extractBlobURLs(child)...)
+			}
+		}
+	case []interface{}:
+		for _, child := range value {
+			urls = append(urls, extractBlobURLs(child)...)
+		}
+	case string:
+		if strings.HasPrefix(value, blobsURL + "/") {
+			urls = append(urls, value)
+		}
+	}
+
+	return urls
+}
@@ -17,7 +17,17 @@
 
 package sysutilities
 
-import "embed"
+import (
+	"bytes"
+	"fmt"
+	"infinite-mitm/pkg/errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
 
 func isAdmin() bool {
 	return true
@@ -26,5 +36,35 @@ func isAdmin() bool {
 func runAsAdmin() {
 }
 
-func installRootCertificate(f *embed.FS, certFilename string) {
+// installRootCertificate trusts the certificate for the current user, in its login keychain; macOS asks for the user password.
+func installRootCertificate(certPath string) *errors.MITMError {
+	if runtime.GOOS != "darwin" {
+		return nil
+	}
+
+	home, mitmErr := GetHomeDirectory()
+	if mitmErr != nil {
+		return mitmErr
+	}
+
+	keychain := filepath.Join(home, "Library", "Keychains", "login.keychain-db")
+	cmd := exec.Command("security", "add-trusted-cert", "-r", "trustRoot", "-k", keychain, certPath)
+	var out bytes.Buffer
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return errors.Create(errors.ErrProxyCertificateException, strings.TrimSpace(fmt.Sprintf("%s %s", err.Error(), out.String())))
+	}
+
+	return nil
+}
+
+func isProcessRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	// signal 0 only checks for the existence of the process
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
 }
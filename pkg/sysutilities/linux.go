@@ -0,0 +1,280 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package sysutilities
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"infinite-mitm/pkg/errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// directory read by update-ca-certificates (Debian, Ubuntu, SteamOS)
+const linuxCertificatesDir = "/usr/local/share/ca-certificates"
+
+// bundles generated by the distro trust store, as listed by crypto/x509
+var linuxCertificateBundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+func checkForRootCertificateOnLinux(cert *x509.Certificate) (bool, *errors.MITMError) {
+	for _, bundle := range linuxCertificateBundles {
+		data, err := os.ReadFile(bundle)
+		if err != nil {
+			continue
+		}
+
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+
+			if block.Type == "CERTIFICATE" && bytes.Equal(block.Bytes, cert.Raw) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, errors.Create(errors.ErrProxyCertificateException, "missing root certificate")
+}
+
+// installRootCertificateOnLinux adds the certificate to the distro trust store, through update-ca-certificates
+// when available or p11-kit otherwise; both require root privileges and prompt for them with sudo.
+func installRootCertificateOnLinux(certPath string) *errors.MITMError {
+	cert, mitmErr := readCertificateFile(certPath)
+	if mitmErr != nil {
+		return mitmErr
+	}
+
+	temp, mitmErr := writeTemporaryPEM(cert)
+	if mitmErr != nil {
+		return mitmErr
+	}
+
+	defer os.Remove(temp)
+
+	if _, err := exec.LookPath("update-ca-certificates"); err == nil {
+		target := filepath.Join(linuxCertificatesDir, cert.Subject.CommonName+".crt")
+		if err := runAsRoot("install", "-D", "-m", "0644", temp, target); err != nil {
+			return errors.Create(errors.ErrProxyCertificateException, err.Error())
+		}
+
+		if err := runAsRoot("update-ca-certificates"); err != nil {
+			return errors.Create(errors.ErrProxyCertificateException, err.Error())
+		}
+
+		return nil
+	}
+
+	if _, err := exec.LookPath("trust"); err == nil {
+		if err := runAsRoot("trust", "anchor", "--store", temp); err != nil {
+			return errors.Create(errors.ErrProxyCertificateException, err.Error())
+		}
+
+		return nil
+	}
+
+	return errors.Create(errors.ErrProxyCertificateException, "neither update-ca-certificates nor trust (p11-kit) could be found")
+}
+
+func uninstallRootCertificateOnLinux(cert *x509.Certificate) *errors.MITMError {
+	if _, err := exec.LookPath("update-ca-certificates"); err == nil {
+		target := filepath.Join(linuxCertificatesDir, cert.Subject.CommonName+".crt")
+		if installed, err := os.ReadFile(target); err != nil || !bytes.Contains(installed, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})) {
+			return nil
+		}
+
+		if err := runAsRoot("rm", "-f", target); err != nil {
+			return errors.Create(errors.ErrProxyCertificateException, err.Error())
+		}
+
+		if err := runAsRoot("update-ca-certificates", "--fresh"); err != nil {
+			return errors.Create(errors.ErrProxyCertificateException, err.Error())
+		}
+
+		return nil
+	}
+
+	temp, mitmErr := writeTemporaryPEM(cert)
+	if mitmErr != nil {
+		return mitmErr
+	}
+
+	defer os.Remove(temp)
+
+	if err := runAsRoot("trust", "anchor", "--remove", temp); err != nil {
+		return errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	return nil
+}
+
+// InstallRootCertificateInWinePrefix trusts the certificate inside a Wine or Proton prefix (e.g., ~/.steam/steam/steamapps/compatdata/1240440/pfx)
+// by adding it to the machine "Root" store of its registry; the prefix must not be running while its registry is updated.
+func InstallRootCertificateInWinePrefix(certPath string, prefix string) *errors.MITMError {
+	cert, mitmErr := readCertificateFile(certPath)
+	if mitmErr != nil {
+		return mitmErr
+	}
+
+	registryPath := filepath.Join(prefix, "system.reg")
+	registry, err := os.ReadFile(registryPath)
+	if err != nil {
+		return errors.Create(errors.ErrProxyCertificateException, fmt.Sprintf("invalid Wine prefix: %s", err.Error()))
+	}
+
+	thumbprint := certificateThumbprint(cert)
+	key := fmt.Sprintf("[Software\\\\Microsoft\\\\SystemCertificates\\\\Root\\\\Certificates\\\\%s]", thumbprint)
+	if bytes.Contains(registry, []byte(key)) {
+		return nil
+	}
+
+	var section strings.Builder
+	section.WriteString(fmt.Sprintf("\n%s %d\n", key, time.Now().Unix()))
+	section.WriteString(fmt.Sprintf("#time=%x\n", windowsFileTime(time.Now())))
+	section.WriteString(formatRegistryBinary("\"Blob\"=hex:", serializeWineCertificate(cert)))
+
+	file, err := os.OpenFile(registryPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	defer file.Close()
+	if _, err := file.WriteString(section.String()); err != nil {
+		return errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	return nil
+}
+
+// serializeWineCertificate encodes the certificate like crypt32 serializes a certificate context: a list of
+// properties (id, reserved, size, data) holding the SHA-1 hash and the DER encoded certificate.
+func serializeWineCertificate(cert *x509.Certificate) []byte {
+	const (
+		certSHA1HashPropID = 3
+		certCertPropID     = 32
+	)
+
+	var buffer bytes.Buffer
+	writeProperty := func(id uint32, data []byte) {
+		for _, value := range []uint32{id, 1, uint32(len(data))} {
+			buffer.Write([]byte{byte(value), byte(value >> 8), byte(value >> 16), byte(value >> 24)})
+		}
+
+		buffer.Write(data)
+	}
+
+	hash := sha1.Sum(cert.Raw)
+	writeProperty(certSHA1HashPropID, hash[:])
+	writeProperty(certCertPropID, cert.Raw)
+	return buffer.Bytes()
+}
+
+// formatRegistryBinary wraps the hex encoded data the way Wine writes its registry files.
+func formatRegistryBinary(prefix string, data []byte) string {
+	const lineWidth = 76
+
+	var builder strings.Builder
+	line := prefix
+	for i, b := range data {
+		value := fmt.Sprintf("%02x", b)
+		if i < len(data)-1 {
+			value += ","
+		}
+
+		if len(line)+len(value) > lineWidth {
+			builder.WriteString(line + "\\\n")
+			line = "  "
+		}
+
+		line += value
+	}
+
+	builder.WriteString(line + "\n")
+	return builder.String()
+}
+
+func windowsFileTime(t time.Time) int64 {
+	// 100-nanosecond intervals since January 1, 1601
+	return t.UnixNano()/100 + 116444736000000000
+}
+
+func readCertificateFile(certPath string) (*x509.Certificate, *errors.MITMError) {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	// accept both PEM (.pem) and DER (.cer) encoded certificates
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	return cert, nil
+}
+
+func writeTemporaryPEM(cert *x509.Certificate) (string, *errors.MITMError) {
+	file, err := os.CreateTemp("", "infinite-mitm-*.crt")
+	if err != nil {
+		return "", errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	defer file.Close()
+	if err := pem.Encode(file, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+		os.Remove(file.Name())
+		return "", errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	// the trust store tools run as root but must be able to read it
+	os.Chmod(file.Name(), 0644)
+	return file.Name(), nil
+}
+
+// runAsRoot runs the command directly when already root, or through sudo which may prompt for a password.
+func runAsRoot(name string, args ...string) error {
+	var cmd *exec.Cmd
+	if os.Geteuid() == 0 {
+		cmd = exec.Command(name, args...)
+	} else {
+		cmd = exec.Command("sudo", append([]string{name}, args...)...)
+	}
+
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
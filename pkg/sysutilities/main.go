@@ -16,11 +16,14 @@ package sysutilities
 
 import (
 	"bytes"
-	"embed"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
 	"infinite-mitm/pkg/errors"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -45,6 +48,18 @@ func GetHomeDirectory() (string, *errors.MITMError) {
 	return home, nil
 }
 
+// GetLocalIP returns the address of the interface used to reach the network (e.g., 192.168.1.20);
+// dialing UDP does not send any packet, it only resolves the outbound route.
+func GetLocalIP() string {
+	conn, err := net.Dial("udp", "8.8.8.8:80")
+	if err != nil {
+		return "127.0.0.1"
+	}
+
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
 func GetTerminalSize() (int, int) {
 	const defaultWidth = 80
 	const defaultHeight = 25
@@ -142,15 +157,24 @@ func ClearTerminal() {
 	cmd.Run()
 }
 
-func CheckForRootCertificate(certName string) (bool, *errors.MITMError) {
+// CheckForRootCertificate reports whether cert is trusted by the current user (or system-wide on Linux); an expired certificate is reported as an error.
+func CheckForRootCertificate(cert *x509.Certificate) (bool, *errors.MITMError) {
 	var installed bool
 	var mitmErr *errors.MITMError
 
+	if time.Now().After(cert.NotAfter) {
+		return false, errors.Create(errors.ErrProxyCertificateException, fmt.Sprintf("root certificate expired on %s", cert.NotAfter.Format(time.DateOnly)))
+	}
+
+	thumbprint := certificateThumbprint(cert)
+
 	switch runtime.GOOS {
 	case "windows":
-		installed, mitmErr = checkForRootCertificateOnWindows(certName)
+		installed, mitmErr = checkForRootCertificateOnWindows(thumbprint)
 	case "darwin":
-		installed, mitmErr = checkForRootCertificateOnDarwin(certName)
+		installed, mitmErr = checkForRootCertificateOnDarwin(cert.Subject.CommonName, thumbprint)
+	case "linux":
+		installed, mitmErr = checkForRootCertificateOnLinux(cert)
 	default:
 		installed, mitmErr = false, nil
 	}
@@ -170,12 +194,43 @@ func RunAsAdmin() {
 	runAsAdmin()
 }
 
-func InstallRootCertificate(f *embed.FS, certFilename string) {
-	installRootCertificate(f, certFilename)
+func IsProcessRunning(pid int) bool {
+	return isProcessRunning(pid)
 }
 
-func checkForRootCertificateOnWindows(certName string) (bool, *errors.MITMError) {
-	cmd := exec.Command("certutil", "-verifystore", "-user", "root", certName)
+func InstallRootCertificate(certPath string) *errors.MITMError {
+	if runtime.GOOS == "linux" {
+		return installRootCertificateOnLinux(certPath)
+	}
+
+	return installRootCertificate(certPath)
+}
+
+// UninstallRootCertificate removes cert from the current user trust store, or the system one on Linux (e.g., after a rotation).
+func UninstallRootCertificate(cert *x509.Certificate) *errors.MITMError {
+	var cmd *exec.Cmd
+	thumbprint := certificateThumbprint(cert)
+
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("certutil", "-delstore", "-user", "root", thumbprint)
+	case "darwin":
+		cmd = exec.Command("security", "delete-certificate", "-Z", thumbprint)
+	case "linux":
+		return uninstallRootCertificateOnLinux(cert)
+	default:
+		return nil
+	}
+
+	if err := cmd.Run(); err != nil {
+		return errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	return nil
+}
+
+func checkForRootCertificateOnWindows(thumbprint string) (bool, *errors.MITMError) {
+	cmd := exec.Command("certutil", "-verifystore", "-user", "root", thumbprint)
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	err := cmd.Run()
@@ -190,8 +245,8 @@ func checkForRootCertificateOnWindows(certName string) (bool, *errors.MITMError)
 	return true, nil
 }
 
-func checkForRootCertificateOnDarwin(certName string) (bool, *errors.MITMError) {
-	cmd := exec.Command("security", "find-certificate", "-a", "-c", certName)
+func checkForRootCertificateOnDarwin(certName string, thumbprint string) (bool, *errors.MITMError) {
+	cmd := exec.Command("security", "find-certificate", "-a", "-Z", "-c", certName)
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	err := cmd.Run()
@@ -199,9 +254,14 @@ func checkForRootCertificateOnDarwin(certName string) (bool, *errors.MITMError)
 		return false, errors.Create(errors.ErrProxyCertificateException, err.Error())
 	}
 
-	if strings.TrimSpace(out.String()) == "" {
+	if !strings.Contains(strings.ToUpper(out.String()), thumbprint) {
 		return false, errors.Create(errors.ErrProxyCertificateException, "missing root certificate")
 	}
 
 	return true, nil
 }
+
+func certificateThumbprint(cert *x509.Certificate) string {
+	sum := sha1.Sum(cert.Raw)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
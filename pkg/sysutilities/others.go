@@ -0,0 +1,40 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package sysutilities
+
+import (
+	"crypto/x509"
+	"infinite-mitm/pkg/errors"
+)
+
+func checkForRootCertificateOnLinux(cert *x509.Certificate) (bool, *errors.MITMError) {
+	return false, errors.Create(errors.ErrProxyCertificateException, "the Linux trust store is only available on Linux")
+}
+
+func installRootCertificateOnLinux(certPath string) *errors.MITMError {
+	return errors.Create(errors.ErrProxyCertificateException, "the Linux trust store is only available on Linux")
+}
+
+func uninstallRootCertificateOnLinux(cert *x509.Certificate) *errors.MITMError {
+	return errors.Create(errors.ErrProxyCertificateException, "the Linux trust store is only available on Linux")
+}
+
+// InstallRootCertificateInWinePrefix is only available on Linux, where the game runs through Wine or Proton.
+func InstallRootCertificateInWinePrefix(certPath string, prefix string) *errors.MITMError {
+	return errors.Create(errors.ErrProxyCertificateException, "Wine prefixes are only supported on Linux")
+}
@@ -18,8 +18,6 @@
 package sysutilities
 
 import (
-	"embed"
-	"fmt"
 	"infinite-mitm/pkg/errors"
 	"log"
 	"os"
@@ -78,15 +76,15 @@ func runAsAdmin() {
 	os.Exit(0)
 }
 
-func installRootCertificate(f *embed.FS, certFilename string) {
-	certData, err := f.ReadFile(fmt.Sprintf("cert/%s", certFilename))
+func installRootCertificate(certPath string) *errors.MITMError {
+	certData, err := os.ReadFile(certPath)
 	if err != nil {
-		log.Fatalln(errors.ErrFatalException, err.Error())
+		return errors.Create(errors.ErrProxyCertificateException, err.Error())
 	}
 
 	rootStore, err := windows.UTF16PtrFromString("ROOT")
 	if err != nil {
-		log.Fatalln(errors.ErrFatalException, err.Error())
+		return errors.Create(errors.ErrProxyCertificateException, err.Error())
 	}
 
 	certStore, err := windows.CertOpenStore(
@@ -96,7 +94,7 @@ func installRootCertificate(f *embed.FS, certFilename string) {
 	)
 
 	if err != nil {
-		log.Fatalln(errors.ErrFatalException, err.Error())
+		return errors.Create(errors.ErrProxyCertificateException, err.Error())
 	}
 
 	defer windows.CertCloseStore(certStore, 0)
@@ -107,12 +105,33 @@ func installRootCertificate(f *embed.FS, certFilename string) {
 	)
 
 	if err != nil {
-		log.Fatalln(errors.ErrFatalException, err.Error())
+		return errors.Create(errors.ErrProxyCertificateException, err.Error())
 	}
 
 	defer windows.CertFreeCertificateContext(cert)
 	err = windows.CertAddCertificateContextToStore(certStore, cert, windows.CERT_STORE_ADD_ALWAYS, nil)
 	if err != nil {
-		log.Fatalln(errors.ErrFatalException, err.Error())
+		return errors.Create(errors.ErrProxyCertificateException, err.Error())
+	}
+
+	return nil
+}
+
+func isProcessRunning(pid int) bool {
+	const stillActive = 259
+
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// the process exists but belongs to another user
+		return err == windows.ERROR_ACCESS_DENIED
+	}
+
+	defer windows.CloseHandle(handle)
+
+	var code uint32
+	if err := windows.GetExitCodeProcess(handle, &code); err != nil {
+		return false
 	}
+
+	return code == stillActive
 }
@@ -0,0 +1,79 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package throttle
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter spreads reads over time so they don't exceed rate bytes per second; a nil or zero-rate limiter never waits.
+type Limiter struct {
+	rate  int64
+	next  time.Time
+	mutex sync.Mutex
+}
+
+type reader struct {
+	source  io.Reader
+	limiter *Limiter
+}
+
+const chunkSize = 32 * 1024
+
+func NewLimiter(rate int64) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &Limiter{rate: rate}
+}
+
+func (l *Limiter) Wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+
+	l.mutex.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.rate) * float64(time.Second)))
+	l.mutex.Unlock()
+
+	time.Sleep(wait)
+}
+
+func (l *Limiter) Reader(source io.Reader) io.Reader {
+	if l == nil {
+		return source
+	}
+
+	return &reader{source: source, limiter: l}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+
+	n, err := r.source.Read(p)
+	r.limiter.Wait(n)
+	return n, err
+}
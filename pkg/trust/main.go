@@ -0,0 +1,278 @@
+// Copyright 2024 Alexis Bize
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trust
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"infinite-mitm/configs"
+	"infinite-mitm/pkg/errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// YAML lists the commands reviewed by the user; the rule commands may only run the allowed ones.
+type YAML struct {
+	Allowed []Command `yaml:"allowed"`
+	Denied  []Command `yaml:"denied"`
+	Version int `yaml:"version"`
+}
+
+// Command is a command of the mitm.yaml rules: the executable it runs, and its arguments and environment as written in the
+// mitm.yaml (the $1 matches and ${name} variables are not replaced); changing any of them requires a new review.
+type Command struct {
+	Path   string `yaml:"path"`
+	Sha256 string `yaml:"sha256"`
+	Args   []string `yaml:"args,omitempty"`
+	Env    map[string]string `yaml:"env,omitempty"`
+}
+
+type hashEntry struct {
+	modTime time.Time
+	size    int64
+	hash    string
+}
+
+const (
+	TrustFilename = "trust.yaml"
+	TrustVersion = 2
+)
+
+var TrustFilepath = filepath.Join(configs.GetConfig().Extra.ProjectDir, TrustFilename)
+
+var (
+	hashes      = map[string]hashEntry{}
+	hashesMutex sync.Mutex
+	fileMutex   sync.Mutex
+)
+
+// ReadTrustConfig returns the reviewed commands; an empty list is returned until the first review,
+// and when the file was written by a previous version (its executables are reviewed again, along with their arguments).
+func ReadTrustConfig() (YAML, *errors.MITMError) {
+	yamlFile, err := os.ReadFile(TrustFilepath)
+	if os.IsNotExist(err) {
+		return YAML{Version: TrustVersion}, nil
+	} else if err != nil {
+		return YAML{}, errors.Create(errors.ErrYAMLReadException, err.Error())
+	}
+
+	var content YAML
+	if err = yaml.Unmarshal(yamlFile, &content); err != nil {
+		return YAML{}, errors.Create(errors.ErrYAMLReadException, err.Error())
+	}
+
+	if content.Version < TrustVersion {
+		return YAML{Version: TrustVersion}, nil
+	}
+
+	if content.Version != TrustVersion {
+		return YAML{}, errors.Create(errors.ErrYAMLReadException, fmt.Sprintf("your %s is outdated, please delete it and restart the application to fix this issue.", TrustFilename))
+	}
+
+	return content, nil
+}
+
+func WriteTrustFile(content YAML) *errors.MITMError {
+	buffer, err := yaml.Marshal(content)
+	if err != nil {
+		return errors.Create(errors.ErrIOWriteException, err.Error())
+	}
+
+	if err := os.WriteFile(TrustFilepath, buffer, 0644); err != nil {
+		return errors.Create(errors.ErrIOWriteException, err.Error())
+	}
+
+	return nil
+}
+
+// ResolveExecutable returns the absolute path of the executable run by a command (e.g., "python" → "/usr/bin/python3.12").
+func ResolveExecutable(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", err
+	}
+
+	if path, err = filepath.Abs(path); err != nil {
+		return "", err
+	}
+
+	return filepath.EvalSymlinks(path)
+}
+
+// HashExecutable returns the SHA-256 of the executable, computed again only when it is modified.
+func HashExecutable(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	hashesMutex.Lock()
+	defer hashesMutex.Unlock()
+
+	if entry, ok := hashes[path]; ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.hash, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	hashes[path] = hashEntry{modTime: info.ModTime(), size: info.Size(), hash: hash}
+	return hash, nil
+}
+
+// String returns the command line of the command (e.g., "/usr/bin/python3.12 scripts/pack.py $1").
+func (c Command) String() string {
+	line := strings.Join(append([]string{c.Path}, c.Args...), " ")
+
+	keys := make([]string, 0, len(c.Env))
+	for key := range c.Env {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	for i := len(keys) - 1; i >= 0; i-- {
+		line = keys[i] + "=" + c.Env[keys[i]] + " " + line
+	}
+
+	return line
+}
+
+// IsAllowed reports whether the command was allowed, and its executable has not been modified since.
+func IsAllowed(command Command) bool {
+	content, mitmErr := ReadTrustConfig()
+	if mitmErr != nil {
+		return false
+	}
+
+	return contains(content.Allowed, command)
+}
+
+// IsReviewed reports whether the command, with its executable as it is now, was either allowed or denied.
+func IsReviewed(command Command) bool {
+	content, mitmErr := ReadTrustConfig()
+	if mitmErr != nil {
+		return false
+	}
+
+	return contains(content.Allowed, command) || contains(content.Denied, command)
+}
+
+// Review records the decision of the user for each command, replacing the previous one.
+func Review(commands []Command, allow bool) *errors.MITMError {
+	if len(commands) == 0 {
+		return nil
+	}
+
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	content, mitmErr := ReadTrustConfig()
+	if mitmErr != nil {
+		return mitmErr
+	}
+
+	for _, command := range commands {
+		hash, err := HashExecutable(command.Path)
+		if err != nil {
+			return errors.Create(errors.ErrIOReadException, err.Error())
+		}
+
+		content.Allowed = without(content.Allowed, command)
+		content.Denied = without(content.Denied, command)
+
+		command.Sha256 = hash
+		if allow {
+			content.Allowed = append(content.Allowed, command)
+		} else {
+			content.Denied = append(content.Denied, command)
+		}
+	}
+
+	content.Version = TrustVersion
+	return WriteTrustFile(content)
+}
+
+func contains(commands []Command, command Command) bool {
+	hash, err := HashExecutable(command.Path)
+	if err != nil {
+		return false
+	}
+
+	for _, reviewed := range commands {
+		if sameCommand(reviewed, command) && strings.EqualFold(reviewed.Sha256, hash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func without(commands []Command, command Command) []Command {
+	var list []Command
+	for _, reviewed := range commands {
+		if !sameCommand(reviewed, command) {
+			list = append(list, reviewed)
+		}
+	}
+
+	return list
+}
+
+func sameCommand(a Command, b Command) bool {
+	if !samePath(a.Path, b.Path) || len(a.Args) != len(b.Args) || len(a.Env) != len(b.Env) {
+		return false
+	}
+
+	for i := range a.Args {
+		if a.Args[i] != b.Args[i] {
+			return false
+		}
+	}
+
+	for key, value := range a.Env {
+		if other, ok := b.Env[key]; !ok || other != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func samePath(a string, b string) bool {
+	if filepath.Separator == '\\' {
+		return strings.EqualFold(filepath.Clean(a), filepath.Clean(b))
+	}
+
+	return filepath.Clean(a) == filepath.Clean(b)
+}